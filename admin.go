@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	resetLockWait = 10 * time.Second       // how long to wait for a download to finish before cancelling it
+	resetLockPoll = 100 * time.Millisecond // how often to check whether the download has finished
+)
+
+// Shut gphotosdl down in the same way as SIGTERM, returning 202 straight
+// away
+func (g *Gphotos) postShutdown(w http.ResponseWriter, r *http.Request) {
+	slog.Info("got shutdown request", "remote", r.RemoteAddr)
+	w.WriteHeader(http.StatusAccepted)
+	g.requestShutdown()
+}
+
+// Ask main to shut gphotosdl down
+func (g *Gphotos) requestShutdown() {
+	g.shutdownOnce.Do(func() {
+		close(g.shutdown)
+	})
+}
+
+// Shut down the web server, waiting up to -shutdown-timeout for
+// requests in progress to finish so no transfers are cut off
+func (g *Gphotos) drainServer() {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	slog.Info("Waiting for requests in progress to finish", "timeout", *shutdownTimeout, "queued", g.queued.Load())
+	err := g.server.Shutdown(ctx)
+	if err != nil {
+		slog.Error("Requests didn't finish before -shutdown-timeout", "err", err)
+	}
+}
+
+// Navigate the page back to the Google Photos home page to clear any
+// stuck state, returning 200 with the AuthStatus or 503 if the page
+// couldn't be recovered
+func (g *Gphotos) postResetPage(w http.ResponseWriter, r *http.Request) {
+	slog.Info("got reset page request")
+	status, err := g.ResetPage()
+	if err != nil {
+		slog.Error("Reset page failed", "err", err)
+		writeError(w, "", err)
+		return
+	}
+	slog.Info("Reset page", "url", status.URL)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(status)
+	if err != nil {
+		slog.Debug("Failed to write reset page response", "err", err)
+	}
+}
+
+// ResetPage navigates the page to the Google Photos home page and
+// checks the browser is still logged in
+//
+// If a download is stuck holding the lock then after resetLockWait
+// this cancels it and waits up to resetLockWait again for the lock.
+// The page is never reset without the lock.
+func (g *Gphotos) ResetPage() (*AuthStatus, error) {
+	err := g.checkBrowser()
+	if err != nil {
+		return nil, err
+	}
+	locked := g.tryLockFor(resetLockWait)
+	if !locked {
+		slog.Warn("Download still running - cancelling it", "waited", resetLockWait)
+		g.cancelRunningJob()
+		locked = g.tryLockFor(resetLockWait)
+	}
+	if !locked {
+		return nil, fmt.Errorf("download still running after cancelling it: %w", errKindResetFailed)
+	}
+	defer g.mu.Unlock()
+
+	err = g.reloadPage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset the page: %v: %w", err, errKindResetFailed)
+	}
+	info, err := g.page.Info()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page info after resetting the page: %v: %w", err, errKindResetFailed)
+	}
+	if !isAuthenticatedURL(info.URL) {
+		return nil, fmt.Errorf("browser isn't logged in after resetting the page, it is showing %q: %w", info.URL, errKindResetFailed)
+	}
+	return &AuthStatus{Authenticated: true, URL: info.URL}, nil
+}
+
+// Try to take the lock for up to wait, returning true if it was taken
+func (g *Gphotos) tryLockFor(wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+	locked := g.mu.TryLock()
+	for !locked && time.Now().Before(deadline) {
+		time.Sleep(resetLockPoll)
+		locked = g.mu.TryLock()
+	}
+	return locked
+}
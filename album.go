@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// gphotosAlbumURL is where album pages are found - a var so tests can
+// use a mock
+var gphotosAlbumURL = "https://photos.google.com/album/"
+
+const (
+	albumPhotoLinks  = `a[href*="photo/"]`
+	albumScrollPause = time.Second // time to let the album grid load after scrolling
+	albumScrollTries = 5           // give up after this many scrolls find no new photos
+)
+
+// Serve the photo IDs in an album as a JSON array
+//
+// The IDs are streamed to the client as they are found so large
+// albums don't need to be held in memory.
+func (g *Gphotos) getAlbum(w http.ResponseWriter, r *http.Request) {
+	albumID := normalizeID(r.PathValue("albumID"))
+	if albumID, ok := strings.CutSuffix(albumID, ".tar"); ok {
+		g.getAlbumTar(w, r, albumID)
+		return
+	}
+	slog.Info("got album request", "id", albumID)
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/json")
+	ids := &jsonArrayWriter{w: w}
+	err := g.ListAlbum(r.Context(), albumID, func(photoID string) error {
+		err := ids.add(photoID)
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+	if err != nil {
+		slog.Error("List album failed", "id", albumID, "err", err)
+		if ids.n == 0 {
+			writeError(w, albumID, err)
+		}
+		// Leave the array unfinished so the client can't mistake
+		// part of the album for all of it
+		return
+	}
+	_ = ids.close()
+	slog.Info("Listed album", "id", albumID, "photos", ids.n)
+}
+
+// jsonArrayWriter writes a JSON array of strings to w one at a time
+type jsonArrayWriter struct {
+	w io.Writer
+	n int // number of strings written
+}
+
+// Write s as the next item of the array
+func (a *jsonArrayWriter) add(s string) error {
+	sep := ",\n"
+	if a.n == 0 {
+		sep = "[\n"
+	}
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(a.w, sep+string(buf))
+	if err != nil {
+		return err
+	}
+	a.n++
+	return nil
+}
+
+// Finish the array
+//
+// This must only be called once all the items have been written, as
+// a client reading an array which isn't finished gets an error.
+func (a *jsonArrayWriter) close() error {
+	end := "\n]\n"
+	if a.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(a.w, end)
+	return err
+}
+
+// Serve every photo in an album as a tar stream
+//
+// The album is listed in its own tab so each photo can be downloaded
+// and streamed as soon as it is found, in the same format as the batch
+// endpoint. If listing the album fails part way through the tar is left
+// unfinished, without its manifest, so the client can't mistake part of
+// the album for all of it.
+func (g *Gphotos) getAlbumTar(w http.ResponseWriter, r *http.Request, albumID string) {
+	slog.Info("got album tar request", "id", albumID)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	ids := make(chan string)
+	listErr := make(chan error, 1)
+	go func() {
+		defer close(ids)
+		listErr <- g.listAlbumInTab(ctx, albumID, func(photoID string) error {
+			select {
+			case ids <- photoID:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	// Wait for the first photo so a bad album gets a proper error
+	photoID, ok := <-ids
+	if !ok {
+		err := <-listErr
+		if err != nil {
+			slog.Error("List album failed", "id", albumID, "err", err)
+			writeError(w, albumID, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": albumID + ".tar"}))
+	t := g.newTarStream(w)
+	for ; ok; photoID, ok = <-ids {
+		if r.Context().Err() != nil {
+			slog.Error("Tar request cancelled", "err", r.Context().Err())
+			return
+		}
+		err := t.add(photoID)
+		if err != nil {
+			slog.Error("Failed to write tar", "id", photoID, "err", err)
+			return
+		}
+	}
+	err := <-listErr
+	if err != nil {
+		slog.Error("List album failed", "id", albumID, "err", err)
+		return
+	}
+	err = t.close()
+	if err != nil {
+		slog.Error("Failed to finish tar", "err", err)
+		return
+	}
+	slog.Info("Finished album tar", "id", albumID, "photos", len(t.manifest))
+}
+
+// ListAlbum calls fn with each photo ID in the album given
+//
+// The album page only shows the photos near the viewport, so this
+// scrolls through it until no new photos appear, removing duplicates.
+func (g *Gphotos) ListAlbum(ctx context.Context, albumID string, fn func(photoID string) error) error {
+	err := checkID("album", albumID)
+	if err != nil {
+		return err
+	}
+	err = g.checkBrowser()
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return listAlbumPage(ctx, g.page, albumID, fn)
+}
+
+// Like ListAlbum but uses a new tab rather than the page, so the
+// download lock isn't held and photos can be downloaded while the
+// album is listed
+func (g *Gphotos) listAlbumInTab(ctx context.Context, albumID string, fn func(photoID string) error) error {
+	err := checkID("album", albumID)
+	if err != nil {
+		return err
+	}
+	err = g.checkBrowser()
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	browser := g.browser
+	g.mu.Unlock()
+	page, err := browser.Page(proto.TargetCreateTarget{URL: "about:blank"})
+	if err != nil {
+		return fmt.Errorf("failed to open tab for album: %w", err)
+	}
+	defer func() {
+		err := page.Close()
+		if err != nil {
+			slog.Debug("Failed to close album tab", "err", err)
+		}
+	}()
+	if *lang != "" {
+		_, err = page.SetExtraHeaders([]string{"Accept-Language", *lang})
+		if err != nil {
+			return fmt.Errorf("failed to set Accept-Language: %w", err)
+		}
+	}
+	return listAlbumPage(ctx, page, albumID, fn)
+}
+
+// Show the album in page and call fn with each photo ID in it
+func listAlbumPage(ctx context.Context, page *rod.Page, albumID string, fn func(photoID string) error) error {
+	err := page.Navigate(accountURL(gphotosAlbumURL) + albumID)
+	if err != nil {
+		return fmt.Errorf("failed to navigate to album %q: %w", albumID, err)
+	}
+	err = waitLoaded(page)
+	if err != nil {
+		return fmt.Errorf("album page load: %w", err)
+	}
+	err = checkGoogleErrorPage(page)
+	if err != nil {
+		return err
+	}
+
+	page = page.Context(ctx)
+	seen := map[string]struct{}{}
+	for tries := 0; tries < albumScrollTries; {
+		res, err := page.Eval(`sel => Array.from(document.querySelectorAll(sel), a => a.getAttribute("href"))`, albumPhotoLinks)
+		if err != nil {
+			return fmt.Errorf("failed to read album photos: %w", err)
+		}
+		found := 0
+		for _, href := range res.Value.Arr() {
+			photoID := photoIDFromHref(href.Str())
+			if photoID == "" {
+				continue
+			}
+			if _, ok := seen[photoID]; ok {
+				continue
+			}
+			seen[photoID] = struct{}{}
+			found++
+			err = fn(photoID)
+			if err != nil {
+				return fmt.Errorf("failed to send photo ID: %w", err)
+			}
+		}
+		slog.Debug("Scrolled album", "id", albumID, "new", found, "total", len(seen))
+		if found == 0 {
+			tries++
+		} else {
+			tries = 0
+		}
+
+		// Scroll the last photo into view to load some more
+		_, err = page.Eval(`sel => {
+			const links = document.querySelectorAll(sel);
+			if (links.length > 0) links[links.length-1].scrollIntoView();
+			window.scrollBy(0, window.innerHeight);
+		}`, albumPhotoLinks)
+		if err != nil {
+			return fmt.Errorf("failed to scroll album: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(albumScrollPause):
+		}
+	}
+	return nil
+}
+
+// Extract the photo ID from an album link like "./album/ALBUMID/photo/PHOTOID"
+func photoIDFromHref(href string) string {
+	i := strings.LastIndex(href, "photo/")
+	if i < 0 {
+		return ""
+	}
+	photoID := href[i+len("photo/"):]
+	if i := strings.IndexAny(photoID, "?#/"); i >= 0 {
+		photoID = photoID[:i]
+	}
+	return photoID
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONArrayWriter(t *testing.T) {
+	for _, test := range []struct {
+		ids    []string
+		finish bool
+		want   string
+	}{
+		{nil, true, "[]\n"},
+		{[]string{"a"}, true, "[\n\"a\"\n]\n"},
+		{[]string{"a", "b-c_d"}, true, "[\n\"a\",\n\"b-c_d\"\n]\n"},
+		{[]string{"a", "b"}, false, "[\n\"a\",\n\"b\""},
+	} {
+		var out strings.Builder
+		a := &jsonArrayWriter{w: &out}
+		for _, id := range test.ids {
+			err := a.add(id)
+			if err != nil {
+				t.Fatalf("%q: unexpected error: %v", test.ids, err)
+			}
+		}
+		if test.finish {
+			err := a.close()
+			if err != nil {
+				t.Fatalf("%q: unexpected error: %v", test.ids, err)
+			}
+		}
+		if got := out.String(); got != test.want {
+			t.Errorf("%q: want %q, got %q", test.ids, test.want, got)
+		}
+		if a.n != len(test.ids) {
+			t.Errorf("%q: want count %d, got %d", test.ids, len(test.ids), a.n)
+		}
+
+		// Only a finished array decodes
+		var got []string
+		err := json.Unmarshal([]byte(out.String()), &got)
+		if !test.finish {
+			if err == nil {
+				t.Errorf("%q: want an unfinished array to fail to decode", test.ids)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: failed to decode: %v", test.ids, err)
+		} else if len(test.ids) > 0 && !reflect.DeepEqual(got, test.ids) {
+			t.Errorf("want %q, got %q", test.ids, got)
+		}
+	}
+}
+
+func TestGetAlbumTar(t *testing.T) {
+	photos := testPhotos()
+	m := newMockPhotos(t, photos)
+	m.albums = map[string][]string{"album1": {"photo1", "menu"}}
+	g := newTestGphotos(t, m)
+
+	r := httptest.NewRequest("GET", "/album/album1.tar", nil)
+	r.SetPathValue("albumID", "album1.tar")
+	w := httptest.NewRecorder()
+	g.getAlbum(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	tr := tar.NewReader(w.Body)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	want := []string{"real1/IMG_0001.JPG", "real4/menu.png", batchManifestName}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("want tar entries %q, got %q", want, names)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// errBadAPIKey is returned when a protected endpoint is called without the -api-key
+var errBadAPIKey = fmt.Errorf("missing or incorrect API key: %w", errKindBadAPIKey)
+
+// Wrap handler so it can only be called with the key set with -api-key
+//
+// The key can be passed as "Authorization: Bearer KEY" or in the
+// X-API-Key header. If no -api-key is set then handler is returned
+// unchanged.
+func requireAPIKey(handler http.HandlerFunc) http.HandlerFunc {
+	if *apiKey == "" {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+			key = bearer
+		}
+		if subtle.ConstantTimeCompare([]byte(key), []byte(*apiKey)) != 1 {
+			slog.Error("Rejected request with bad API key", "path", r.URL.Path, "remote", r.RemoteAddr)
+			writeError(w, "", errBadAPIKey)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// AuthStatus is the result of an authentication check
+type AuthStatus struct {
+	Authenticated bool   `json:"authenticated"`
+	URL           string `json:"url"`
+	Email         string `json:"email,omitempty"`
+}
+
+// Match an email address in the account button label
+var emailRe = regexp.MustCompile(`[^\s()]+@[^\s()]+`)
+
+// Serve the authentication status
+//
+// This returns 200 if the browser is logged in or 401 if not, along
+// with the AuthStatus as JSON.
+func (g *Gphotos) getAuth(w http.ResponseWriter, r *http.Request) {
+	slog.Info("got auth request")
+	status, err := g.CheckAuth()
+	if err != nil {
+		slog.Error("Auth check failed", "err", err)
+		writeError(w, "", err)
+		return
+	}
+	slog.Info("Auth check", "authenticated", status.Authenticated, "email", status.Email)
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Authenticated {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	err = json.NewEncoder(w).Encode(status)
+	if err != nil {
+		slog.Debug("Failed to write auth response", "err", err)
+	}
+}
+
+// CheckAuth navigates to the Google Photos home page to see whether
+// the browser is still logged in
+func (g *Gphotos) CheckAuth() (*AuthStatus, error) {
+	err := g.checkBrowser()
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	err = g.page.Navigate(accountURL(gphotosURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to navigate to gphotos: %w", err)
+	}
+	err = g.waitPage()
+	if err != nil {
+		return nil, fmt.Errorf("gphotos page load: %w", err)
+	}
+	info, err := g.page.Info()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page info: %w", err)
+	}
+	status := &AuthStatus{
+		Authenticated: isAuthenticatedURL(info.URL),
+		URL:           info.URL,
+	}
+	if status.Authenticated {
+		status.Email, err = g.accountEmail()
+		if err != nil {
+			slog.Debug("Couldn't find account email", "err", err)
+		}
+	}
+	return status, nil
+}
+
+// Read the email of the logged in account from the account button
+func (g *Gphotos) accountEmail() (string, error) {
+	res, err := g.page.Eval(`() => {
+		const a = document.querySelector('a[aria-label^="Google Account"]');
+		return a ? a.getAttribute("aria-label") : "";
+	}`)
+	if err != nil {
+		return "", err
+	}
+	email := emailRe.FindString(res.Value.Str())
+	if email == "" {
+		return "", errors.New("account button not found")
+	}
+	return email, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// batchManifestName is the name of the manifest in a batch tar stream
+const batchManifestName = "manifest.json"
+
+// BatchItem is the status of one photo in a batch download
+type BatchItem struct {
+	ID             string `json:"id"`
+	Status         int    `json:"status"`
+	Code           string `json:"code,omitempty"`            // error code from /error-kinds
+	UpstreamStatus int    `json:"upstream_status,omitempty"` // status returned by Google if it caused the error
+	Error          string `json:"error,omitempty"`
+	Path           string `json:"path,omitempty"` // path of the file in the archive
+	Size           int64  `json:"size,omitempty"`
+}
+
+// Record err in the item
+func (item *BatchItem) setError(err error) {
+	kind := kindOf(err)
+	item.Status = kind.Status
+	item.Code = kind.Code
+	item.UpstreamStatus = upstreamStatusOf(err)
+	item.Error = err.Error()
+}
+
+// Serve a batch of photo IDs as a tar stream
+//
+// The request body is a JSON array of photo IDs. Each photo is written
+// to the tar stream as PHOTOID/NAME as soon as it is downloaded,
+// followed by a manifest.json with the status of each photo so
+// failures don't abort the batch.
+func (g *Gphotos) postBatch(w http.ResponseWriter, r *http.Request) {
+	var photoIDs []string
+	err := json.NewDecoder(r.Body).Decode(&photoIDs)
+	if err != nil {
+		writeError(w, "", fmt.Errorf("body must be a JSON array of photo IDs: %v: %w", err, errKindBadRequest))
+		return
+	}
+	for i := range photoIDs {
+		photoIDs[i] = normalizeID(photoIDs[i])
+	}
+	slog.Info("got batch request", "photos", len(photoIDs))
+	if *outputDir != "" {
+		g.batchToDir(w, r, photoIDs)
+		return
+	}
+	g.serveTar(w, r, photoIDs)
+}
+
+// Download photoIDs writing them to w as a tar stream followed by
+// the manifest
+func (g *Gphotos) serveTar(w http.ResponseWriter, r *http.Request, photoIDs []string) {
+	t := g.newTarStream(w)
+	for _, photoID := range photoIDs {
+		if r.Context().Err() != nil {
+			slog.Error("Tar request cancelled", "err", r.Context().Err())
+			return
+		}
+		err := t.add(photoID)
+		if err != nil {
+			// The stream is broken so there is no point continuing
+			slog.Error("Failed to write tar", "id", photoID, "err", err)
+			return
+		}
+	}
+	err := t.close()
+	if err != nil {
+		slog.Error("Failed to finish tar", "err", err)
+		return
+	}
+	slog.Info("Finished tar", "photos", len(photoIDs))
+}
+
+// tarStream writes downloaded photos to a tar stream one at a time
+type tarStream struct {
+	g        *Gphotos
+	tw       *tar.Writer
+	manifest []BatchItem // status of each photo added
+}
+
+// Start a tar stream to w
+func (g *Gphotos) newTarStream(w http.ResponseWriter) *tarStream {
+	w.Header().Set("Content-Type", "application/x-tar")
+	return &tarStream{g: g, tw: tar.NewWriter(w)}
+}
+
+// Download photoID and add it to the stream
+//
+// An error is only returned if the stream is broken.
+func (t *tarStream) add(photoID string) error {
+	item, err := t.g.tarPhoto(t.tw, photoID)
+	t.manifest = append(t.manifest, item)
+	return err
+}
+
+// Finish the stream with the manifest
+//
+// This must only be called once all the photos have been added.
+func (t *tarStream) close() error {
+	err := writeTarManifest(t.tw, t.manifest)
+	if err != nil {
+		return err
+	}
+	return t.tw.Close()
+}
+
+// Download photoID and write it to tw, returning its status
+//
+// Download failures are recorded in the BatchItem - an error is only
+// returned if writing to tw failed.
+func (g *Gphotos) tarPhoto(tw *tar.Writer, photoID string) (item BatchItem, err error) {
+	item = BatchItem{ID: photoID, Status: http.StatusOK}
+	d, err := g.Download(photoID)
+	if err != nil {
+		slog.Error("Batch download failed", "id", photoID, "err", err)
+		item.setError(err)
+		return item, nil
+	}
+	defer func() {
+		err := os.Remove(d.Path)
+		if err != nil {
+			slog.Error("Failed to remove downloaded photo", "id", photoID, "path", d.Path, "err", err)
+		}
+	}()
+	// Shared photo IDs have "/" and "?" in so use the photo's own ID
+	fileID := pagePhotoID(photoID)
+	name := d.Name
+	if name == "" {
+		name = fileID
+	}
+	item.Path = path.Join(fileID, name)
+	in, err := os.Open(d.Path)
+	if err != nil {
+		item.setError(err)
+		return item, nil
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	fi, err := in.Stat()
+	if err != nil {
+		item.setError(err)
+		return item, nil
+	}
+	item.Size = fi.Size()
+	// Date the file by when the photo was taken, like Google Takeout
+	modTime := captureTime(d.Path)
+	if modTime.IsZero() {
+		modTime = fi.ModTime()
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    item.Path,
+		Mode:    0644,
+		Size:    fi.Size(),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return item, err
+	}
+	_, err = io.Copy(tw, in)
+	if err != nil {
+		return item, err
+	}
+	return item, tw.Flush()
+}
+
+// Write the manifest of the batch to the end of tw
+func writeTarManifest(tw *tar.Writer, manifest []BatchItem) error {
+	buf, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    batchManifestName,
+		Mode:    0644,
+		Size:    int64(len(buf)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(buf)
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestTarPhotoShared(t *testing.T) {
+	photos := testPhotos()
+	m := newMockPhotos(t, photos)
+	g := newTestGphotos(t, m)
+	photoID := sharedPhotoID("share1", "real1", "a key/with?odd=chars")
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	item, err := g.tarPhoto(tw, photoID)
+	if err != nil {
+		t.Fatalf("failed to write tar: %v", err)
+	}
+	if item.Error != "" {
+		t.Fatalf("download of %q failed: %s", photoID, item.Error)
+	}
+	err = tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "real1/IMG_0001.JPG"
+	if item.Path != want {
+		t.Errorf("want manifest path %q, got %q", want, item.Path)
+	}
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("failed to read tar: %v", err)
+	}
+	if hdr.Name != want {
+		t.Errorf("want tar entry %q, got %q", want, hdr.Name)
+	}
+	got, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatalf("failed to read tar entry: %v", err)
+	}
+	if !bytes.Equal(got, photos["photo1"].data) {
+		t.Errorf("tar entry doesn't match the photo served")
+	}
+}
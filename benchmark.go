@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+)
+
+// Download photoID n times then log the throughput and how long each
+// phase of the downloads took
+//
+// Every download goes through the browser, so this measures what the
+// proxy can sustain on this machine, which is useful for choosing
+// rclone's --transfers and for comparing options.
+func (g *Gphotos) runBenchmark(n int, photoID string) error {
+	var (
+		phases = map[string][]time.Duration{}
+		order  []string // phase names in the order they happen
+		failed int
+		size   int64
+		start  = time.Now()
+	)
+	record := func(phase string, d time.Duration) {
+		if _, found := phases[phase]; !found {
+			order = append(order, phase)
+		}
+		phases[phase] = append(phases[phase], d)
+	}
+	slog.Info("Starting benchmark", "id", photoID, "downloads", n)
+	for i := 1; i <= n; i++ {
+		began := time.Now()
+		d, err := g.Download(photoID)
+		took := time.Since(began)
+		if err != nil {
+			failed++
+			slog.Error("Benchmark download failed", "try", i, "err", err)
+			continue
+		}
+		err = os.Remove(d.Path)
+		if err != nil {
+			slog.Error("Failed to remove downloaded photo", "path", d.Path, "err", err)
+		}
+		size += d.Size
+		d.timings.each(record)
+		record("total", took)
+		slog.Info("Benchmark download", "try", i, "of", n, "duration", took, "size", d.Size)
+	}
+	elapsed := time.Since(start)
+	succeeded := n - failed
+	slog.Info("Benchmark finished",
+		"downloads", succeeded,
+		"failed", failed,
+		"elapsed", elapsed,
+		"per_minute", fmt.Sprintf("%.1f", float64(succeeded)/elapsed.Minutes()),
+		"bytes_per_second", fmt.Sprintf("%.0f", float64(size)/elapsed.Seconds()),
+	)
+	for _, phase := range order {
+		durations := phases[phase]
+		slices.Sort(durations)
+		var sum time.Duration
+		for _, d := range durations {
+			sum += d
+		}
+		slog.Info("Benchmark phase",
+			"phase", phase,
+			"min", durations[0],
+			"median", durations[len(durations)/2],
+			"mean", sum/time.Duration(len(durations)),
+			"max", durations[len(durations)-1],
+		)
+	}
+	if succeeded == 0 {
+		return errors.New("all the benchmark downloads failed")
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+const (
+	restartRetryDelay  = 10 * time.Second // time to wait before retrying a failed browser restart
+	restartMaxDelay    = 10 * time.Minute // the most the restart delay is allowed to grow to
+	restartStableAfter = 5 * time.Minute  // a browser running this long has restarted successfully
+)
+
+// errBrowserRestarting is returned for requests made while the browser is being restarted
+var errBrowserRestarting = fmt.Errorf("browser is restarting: %w", errKindRestarting)
+
+// How long to suggest clients wait if the restart should have finished already
+const restartRetryMin = time.Second
+
+// errBrowserStarting is returned when a download is requested before the browser has started
+var errBrowserStarting = fmt.Errorf("browser is starting: %w", errKindStarting)
+
+// errBrowserDegraded is returned once the browser has failed to restart too many times
+var errBrowserDegraded = fmt.Errorf("browser keeps failing to restart - restart gphotosdl: %w", errKindDegraded)
+
+// Check the browser is available for use
+func (g *Gphotos) checkBrowser() error {
+	if g.starting.Load() {
+		return errBrowserStarting
+	}
+	if g.degraded.Load() {
+		return errBrowserDegraded
+	}
+	if g.restarting.Load() {
+		return withRetryAfter(errBrowserRestarting, g.restartRemaining())
+	}
+	return nil
+}
+
+// Start the browser, keeping track of how long it usually takes
+func (g *Gphotos) timeStart() error {
+	start := time.Now()
+	err := g.startBrowser()
+	if err != nil {
+		return err
+	}
+	took := time.Since(start)
+	if prev := time.Duration(g.startTime.Load()); prev > 0 {
+		// Smooth out the odd slow start
+		took = (3*prev + took) / 4
+	}
+	g.startTime.Store(int64(took))
+	return nil
+}
+
+// Returns an estimate of how long the restart in progress will take
+// to finish
+func (g *Gphotos) restartRemaining() time.Duration {
+	remaining := time.Until(time.Unix(0, g.restartDue.Load()))
+	return max(remaining, restartRetryMin)
+}
+
+// Watch the browser given and restart it if it crashes or disconnects
+func (g *Gphotos) watchBrowser(browser *rod.Browser) {
+	crashed := map[string]bool{
+		proto.TargetTargetCrashed{}.ProtoEvent():    true,
+		proto.InspectorTargetCrashed{}.ProtoEvent(): true,
+	}
+	reason := reasonDisconnected
+	for msg := range browser.Event() {
+		if crashed[msg.Method] {
+			reason = reasonCrashed
+			break
+		}
+	}
+	if g.closing.Load() || g.restarting.Load() {
+		return
+	}
+	g.crashes.crashed()
+	g.recover(reason)
+}
+
+// Restart the browser every lifetime to stop it using ever more memory
+func (g *Gphotos) restartEvery(lifetime time.Duration) {
+	for range time.Tick(lifetime) {
+		slog.Info("Browser reached -max-lifetime - restarting it", "max_lifetime", lifetime)
+		g.restartBrowser(false)
+	}
+}
+
+// Restart the browser, waiting for any download in progress to finish
+//
+// If the browser crashed it is killed first, so a download in progress
+// fails straight away rather than waiting on a browser which has gone.
+// Only one restart runs at once.
+//
+// Requests made while this is happening get a 503 error with a
+// Retry-After header of when the restart should have finished, based
+// on how long the browser has taken to start before.
+//
+// A restart fails if the browser doesn't start or if it crashed
+// within restartStableAfter of starting. Each failure doubles the delay before the
+// next attempt, and after -max-restarts failures in a row the browser
+// is left stopped and requests get errBrowserDegraded, rather than
+// relaunching it over and over.
+func (g *Gphotos) restartBrowser(crashed bool) {
+	if g.degraded.Load() {
+		return
+	}
+	if !g.restarting.CompareAndSwap(false, true) {
+		return
+	}
+	defer g.restarting.Store(false)
+	if crashed {
+		// Kill the browser before taking the lock so a download stuck
+		// waiting on it fails rather than holding the lock forever
+		g.launcher.Kill()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !crashed || time.Since(g.startedAt) >= restartStableAfter {
+		g.restartFailures = 0
+	} else {
+		g.restartFailures++
+	}
+	_ = g.browser.Close()
+	g.launcher.Kill()
+	for {
+		if *maxRestarts > 0 && g.restartFailures >= *maxRestarts {
+			g.degraded.Store(true)
+			slog.Error("Browser failed to restart too many times in a row - giving up. Requests will fail with 503 until gphotosdl is restarted.", "failures", g.restartFailures)
+			return
+		}
+		var delay time.Duration
+		if g.restartFailures > 0 {
+			delay = restartDelay(g.restartFailures)
+		}
+		g.restartDue.Store(time.Now().Add(delay + time.Duration(g.startTime.Load())).UnixNano())
+		if delay > 0 {
+			slog.Warn("Waiting before restarting browser", "failures", g.restartFailures, "delay", delay)
+			time.Sleep(delay)
+		}
+		err := g.timeStart()
+		if err == nil {
+			break
+		}
+		g.restartFailures++
+		slog.Error("Failed to restart browser", "err", err, "failures", g.restartFailures)
+	}
+	slog.Info("Browser restarted")
+}
+
+// Returns how long to wait before restarting the browser after
+// failures restart failures in a row
+func restartDelay(failures int) time.Duration {
+	delay := restartRetryDelay
+	for i := 1; i < failures && delay < restartMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, restartMaxDelay)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileCache holds downloaded files which failed to be sent to the
+// client so a retry can be served from disk rather than downloaded
+// again.
+//
+// Files are removed from disk when they expire.
+type fileCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry is a single file in the fileCache
+type cacheEntry struct {
+	d     *Downloaded
+	timer *time.Timer
+}
+
+// newFileCache makes a new empty fileCache
+func newFileCache() *fileCache {
+	return &fileCache{
+		entries: map[string]*cacheEntry{},
+	}
+}
+
+// Put the downloaded file into the cache under id for ttl
+func (c *fileCache) put(id string, d *Downloaded, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[id]; ok && old.d.Path != d.Path {
+		old.timer.Stop()
+		removeCacheFile(id, old.d.Path)
+	}
+	entry := &cacheEntry{d: d}
+	entry.timer = time.AfterFunc(ttl, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.entries[id] != entry {
+			return
+		}
+		delete(c.entries, id)
+		slog.Debug("Retry cache entry expired", "id", id, "path", d.Path)
+		removeCacheFile(id, d.Path)
+	})
+	c.entries[id] = entry
+	slog.Debug("Kept photo in retry cache", "id", id, "path", d.Path, "ttl", ttl)
+}
+
+// Take the file for id out of the cache
+//
+// The caller is responsible for the file after this.
+func (c *fileCache) take(id string) (d *Downloaded, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry.timer.Stop()
+	delete(c.entries, id)
+	return entry.d, true
+}
+
+// Remove a file which was in the cache
+func removeCacheFile(id, path string) {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove cached photo", "id", id, "path", path, "err", err)
+	}
+}
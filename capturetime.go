@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// EXIF tags and formats used to find when a photo was taken
+const (
+	exifIFDPointer         = 0x8769                // IFD0 tag pointing to the EXIF IFD
+	exifDateTimeOriginal   = 0x9003                // when the photo was taken, in local time
+	exifOffsetTimeOriginal = 0x9011                // time zone of exifDateTimeOriginal, eg "+01:00"
+	exifDateLayout         = "2006:01:02 15:04:05" // layout of exifDateTimeOriginal
+	exifOffsetLayout       = "-07:00"              // layout of exifOffsetTimeOriginal
+)
+
+// How much of the start of a file to search for EXIF data
+//
+// This finds the APP1 segment of a JPEG, which must come first, and
+// the EXIF item of a HEIC written by a phone.
+const exifSearchSize = 1 << 20
+
+// exifHeader comes before the TIFF structure holding the EXIF data
+var exifHeader = []byte("Exif\x00\x00")
+
+// mp4Epoch is the time MP4 and QuickTime timestamps count from
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// errNoCaptureTime is returned if a file doesn't say when it was taken
+var errNoCaptureTime = errors.New("no capture time found")
+
+// Returns when the photo or video in the file at path was taken, or
+// the zero time if it doesn't say
+//
+// Photos use the EXIF DateTimeOriginal, which is in the time zone of
+// OffsetTimeOriginal if the camera set it and the local time zone if
+// not. Videos use the creation time of their movie header.
+func captureTime(path string) time.Time {
+	f, err := os.Open(path)
+	if err != nil {
+		slog.Debug("Failed to open file to read capture time", "path", path, "err", err)
+		return time.Time{}
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	t, err := readCaptureTime(f)
+	if err != nil {
+		slog.Debug("Couldn't read capture time", "path", path, "err", err)
+		return time.Time{}
+	}
+	return t
+}
+
+// Read when the photo or video in r was taken
+func readCaptureTime(r io.ReadSeeker) (time.Time, error) {
+	head := make([]byte, exifSearchSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return time.Time{}, err
+	}
+	head = head[:n]
+	if i := bytes.Index(head, exifHeader); i >= 0 {
+		t, err := exifCaptureTime(head[i+len(exifHeader):])
+		if err == nil {
+			return t, nil
+		}
+		slog.Debug("Failed to read EXIF capture time", "err", err)
+	}
+	if len(head) >= 8 && string(head[4:8]) == "ftyp" {
+		return mp4CaptureTime(r)
+	}
+	return time.Time{}, errNoCaptureTime
+}
+
+// Read DateTimeOriginal from the TIFF structure of EXIF data
+func exifCaptureTime(tiff []byte) (time.Time, error) {
+	if len(tiff) < 8 {
+		return time.Time{}, errors.New("EXIF data too short")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, errors.New("bad TIFF header")
+	}
+	pointer, ok := ifdValue(tiff, order, order.Uint32(tiff[4:]), exifIFDPointer)
+	if !ok || len(pointer) != 4 {
+		return time.Time{}, errNoCaptureTime
+	}
+	exifIFD := order.Uint32(pointer)
+	date, ok := ifdValue(tiff, order, exifIFD, exifDateTimeOriginal)
+	if !ok {
+		return time.Time{}, errNoCaptureTime
+	}
+	loc := time.Local
+	if offset, ok := ifdValue(tiff, order, exifIFD, exifOffsetTimeOriginal); ok {
+		t, err := time.Parse(exifOffsetLayout, exifString(offset))
+		if err == nil {
+			loc = t.Location()
+		}
+	}
+	t, err := time.ParseInLocation(exifDateLayout, exifString(date), loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad DateTimeOriginal: %w", err)
+	}
+	return t, nil
+}
+
+// Returns the EXIF ASCII value b as a string
+func exifString(b []byte) string {
+	return strings.TrimRight(string(b), "\x00 ")
+}
+
+// Returns the bytes of the value of tag in the IFD at offset ifd of
+// tiff, or false if it isn't there
+func ifdValue(tiff []byte, order binary.ByteOrder, ifd uint32, tag uint16) ([]byte, bool) {
+	// Size in bytes of each TIFF type
+	typeSizes := map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+	if uint64(ifd)+2 > uint64(len(tiff)) {
+		return nil, false
+	}
+	entries := uint64(order.Uint16(tiff[ifd:]))
+	for i := uint64(0); i < entries; i++ {
+		entry := uint64(ifd) + 2 + 12*i
+		if entry+12 > uint64(len(tiff)) {
+			return nil, false
+		}
+		if order.Uint16(tiff[entry:]) != tag {
+			continue
+		}
+		size, ok := typeSizes[order.Uint16(tiff[entry+2:])]
+		if !ok {
+			return nil, false
+		}
+		n := uint64(size) * uint64(order.Uint32(tiff[entry+4:]))
+		start := entry + 8
+		if n > 4 {
+			start = uint64(order.Uint32(tiff[entry+8:]))
+		}
+		if start+n > uint64(len(tiff)) {
+			return nil, false
+		}
+		return tiff[start : start+n], true
+	}
+	return nil, false
+}
+
+// Read the creation time from the movie header of an MP4 or QuickTime
+// file
+func mp4CaptureTime(r io.ReadSeeker) (time.Time, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return time.Time{}, err
+	}
+	moov, moovEnd, err := findBox(r, 0, end, "moov")
+	if err != nil {
+		return time.Time{}, err
+	}
+	mvhd, _, err := findBox(r, moov, moovEnd, "mvhd")
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, err = r.Seek(mvhd, io.SeekStart)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var header [12]byte
+	_, err = io.ReadFull(r, header[:])
+	if err != nil {
+		return time.Time{}, err
+	}
+	// Version 1 headers have 64 bit times after the version and flags
+	var seconds uint64
+	if header[0] == 1 {
+		seconds = binary.BigEndian.Uint64(header[4:])
+	} else {
+		seconds = uint64(binary.BigEndian.Uint32(header[4:]))
+	}
+	if seconds == 0 {
+		return time.Time{}, errNoCaptureTime
+	}
+	return mp4Epoch.Add(time.Duration(seconds) * time.Second), nil
+}
+
+// Find the box of type typ between start and end of r, returning where
+// its contents start and end
+func findBox(r io.ReadSeeker, start, end int64, typ string) (int64, int64, error) {
+	for offset := start; offset+8 <= end; {
+		_, err := r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return 0, 0, err
+		}
+		var header [16]byte
+		_, err = io.ReadFull(r, header[:8])
+		if err != nil {
+			return 0, 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// The box runs to the end of the file
+			size = end - offset
+		case 1:
+			// The size is in the 64 bits after the type
+			_, err = io.ReadFull(r, header[8:])
+			if err != nil {
+				return 0, 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			return 0, 0, fmt.Errorf("bad %q box size %d", header[4:8], size)
+		}
+		if string(header[4:8]) == typ {
+			return offset + headerSize, offset + size, nil
+		}
+		offset += size
+	}
+	return 0, 0, fmt.Errorf("no %q box: %w", typ, errNoCaptureTime)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// Make the TIFF structure of EXIF data with DateTimeOriginal set to
+// date and OffsetTimeOriginal set to offset if not blank
+func makeTIFF(order binary.ByteOrder, date, offset string) []byte {
+	type entry struct {
+		tag   uint16
+		value string
+	}
+	entries := []entry{{exifDateTimeOriginal, date}}
+	if offset != "" {
+		entries = append(entries, entry{exifOffsetTimeOriginal, offset})
+	}
+	var b bytes.Buffer
+	put := func(values ...any) {
+		for _, v := range values {
+			_ = binary.Write(&b, order, v)
+		}
+	}
+	if order == binary.LittleEndian {
+		b.WriteString("II*\x00")
+	} else {
+		b.WriteString("MM\x00*")
+	}
+	put(uint32(8))
+	// IFD0 with just the pointer to the EXIF IFD
+	exifIFD := uint32(8 + 2 + 12 + 4)
+	put(uint16(1))
+	put(uint16(exifIFDPointer), uint16(4), uint32(1), exifIFD)
+	put(uint32(0))
+	// EXIF IFD with the strings after it
+	data := exifIFD + 2 + 12*uint32(len(entries)) + 4
+	put(uint16(len(entries)))
+	for _, e := range entries {
+		n := uint32(len(e.value) + 1)
+		put(e.tag, uint16(2), n, data)
+		data += n
+	}
+	put(uint32(0))
+	for _, e := range entries {
+		b.WriteString(e.value + "\x00")
+	}
+	return b.Bytes()
+}
+
+// Make a JPEG with the EXIF data in tiff
+func makeJPEG(tiff []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("\xff\xd8\xff\xe1")
+	_ = binary.Write(&b, binary.BigEndian, uint16(2+len(exifHeader)+len(tiff)))
+	b.Write(exifHeader)
+	b.Write(tiff)
+	b.WriteString("\xff\xd9")
+	return b.Bytes()
+}
+
+// Make an MP4 box of type typ
+func makeBox(typ string, contents ...[]byte) []byte {
+	payload := bytes.Join(contents, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(b, typ...), payload...)
+}
+
+// Make the contents of a movie header created at t
+func makeMvhd(version byte, t time.Time) []byte {
+	b := []byte{version, 0, 0, 0}
+	seconds := uint64(0)
+	if !t.IsZero() {
+		seconds = uint64(t.Sub(mp4Epoch) / time.Second)
+	}
+	if version == 1 {
+		b = binary.BigEndian.AppendUint64(b, seconds)
+	} else {
+		b = binary.BigEndian.AppendUint32(b, uint32(seconds))
+	}
+	return append(b, make([]byte, 80)...)
+}
+
+func TestReadCaptureTime(t *testing.T) {
+	taken := time.Date(2024, 6, 1, 12, 30, 45, 0, time.UTC)
+	ftyp := makeBox("ftyp", []byte("isom\x00\x00\x02\x00"))
+	for _, test := range []struct {
+		name string
+		in   []byte
+		want time.Time // zero for an error
+	}{
+		{"JPEG with offset", makeJPEG(makeTIFF(binary.LittleEndian, "2024:06:01 14:30:45", "+02:00")), taken},
+		{"JPEG big endian", makeJPEG(makeTIFF(binary.BigEndian, "2024:06:01 12:30:45", "+00:00")), taken},
+		{"JPEG local time", makeJPEG(makeTIFF(binary.LittleEndian, "2024:06:01 12:30:45", "")), time.Date(2024, 6, 1, 12, 30, 45, 0, time.Local)},
+		{"JPEG unset date", makeJPEG(makeTIFF(binary.LittleEndian, "0000:00:00 00:00:00", "")), time.Time{}},
+		{"JPEG bad EXIF", makeJPEG([]byte("II*\x00\xff\xff\xff\xff")), time.Time{}},
+		{"MP4", bytes.Join([][]byte{ftyp, makeBox("moov", makeBox("mvhd", makeMvhd(0, taken))), makeBox("mdat", []byte("video"))}, nil), taken},
+		{"MP4 moov last", bytes.Join([][]byte{ftyp, makeBox("mdat", []byte("video")), makeBox("moov", makeBox("trak"), makeBox("mvhd", makeMvhd(1, taken)))}, nil), taken},
+		{"MP4 unset time", bytes.Join([][]byte{ftyp, makeBox("moov", makeBox("mvhd", makeMvhd(0, time.Time{})))}, nil), time.Time{}},
+		{"MP4 no moov", bytes.Join([][]byte{ftyp, makeBox("mdat", []byte("video"))}, nil), time.Time{}},
+		{"PNG", []byte("\x89PNG\r\n\x1a\nno metadata here"), time.Time{}},
+	} {
+		got, err := readCaptureTime(bytes.NewReader(test.in))
+		if test.want.IsZero() {
+			if err == nil {
+				t.Errorf("%s: want an error, got %v", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("%s: want %v, got %v", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// Exit codes for -check
+const (
+	checkOK       = 0 // the browser is logged in
+	checkFailed   = 2 // the check couldn't be done, eg the browser didn't start
+	checkNotAuthd = 3 // the browser isn't logged in so -login is needed
+)
+
+// Start the browser, check it is logged in, then close it, returning
+// the exit code for -check
+//
+// This lets scripts find out that the login needs renewing before an
+// rclone job fails because of it.
+func (g *Gphotos) runCheck() int {
+	err := g.Start()
+	if g.browser != nil {
+		g.Close()
+	} else if g.launcher != nil {
+		g.launcher.Kill()
+	}
+	switch {
+	case err == nil:
+		slog.Info("Browser is logged in")
+		return checkOK
+	case errors.Is(err, errKindNotAuth):
+		slog.Error("Browser is not logged in - re-run with -login", "err", err)
+		return checkNotAuthd
+	default:
+		slog.Error("Login check failed", "err", err)
+		return checkFailed
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Chrome major versions which change what gphotosdl can do
+//
+// There is no upper limit as Chrome updates itself every few weeks, so
+// one would be out of date and warn everyone almost straight away.
+const (
+	minChromeVersion     = 112 // first with the new headless mode
+	oldHeadlessRemovedIn = 132 // -headless old doesn't exist from here on
+)
+
+// Log the version of the browser and warn if it is too old for
+// gphotosdl
+func (g *Gphotos) checkBrowserVersion() {
+	v, err := proto.BrowserGetVersion{}.Call(g.browser)
+	if err != nil {
+		slog.Warn("Failed to read browser version", "err", err)
+		return
+	}
+	slog.Info("Browser version", "product", v.Product, "protocol", v.ProtocolVersion, "js", v.JsVersion)
+	major := chromeMajorVersion(v.Product)
+	switch {
+	case major == 0:
+		slog.Warn("Couldn't find the browser major version - unknown browsers may not work", "product", v.Product)
+	case major < minChromeVersion:
+		slog.Warn("Browser is older than gphotosdl supports - update it if downloads fail", "version", major, "min_version", minChromeVersion)
+	}
+	if *headlessMode == "old" && !*show && major >= oldHeadlessRemovedIn {
+		slog.Warn("This browser doesn't have the old headless mode - use -headless new", "version", major)
+	}
+}
+
+// Returns the major version from a product like
+// "HeadlessChrome/131.0.6778.85" or 0 if not found
+func chromeMajorVersion(product string) int {
+	_, version, ok := strings.Cut(product, "/")
+	if !ok {
+		return 0
+	}
+	major, _, _ := strings.Cut(version, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return 0
+	}
+	return n
+}
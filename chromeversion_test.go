@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestChromeMajorVersion(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want int
+	}{
+		{"HeadlessChrome/131.0.6778.85", 131},
+		{"Chrome/142.0.7444.59", 142},
+		{"Chrome/99", 99},
+		{"Chrome/", 0},
+		{"Chrome/abc.1", 0},
+		{"Firefox", 0},
+		{"", 0},
+	} {
+		got := chromeMajorVersion(test.in)
+		if got != test.want {
+			t.Errorf("%q: want %d, got %d", test.in, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Set any flags not already set on the command line or from the
+// environment from the config file given.
+//
+// The config file is a simple YAML file of flag names and values, eg
+//
+//	addr: "localhost:8282"
+//	wait: stable
+//	wait-timeout: 1m
+//	debug: true
+//
+// Only "key: value" lines are supported. Blank lines and lines
+// starting with # are ignored.
+func setFlagsFromConfigFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return fmt.Errorf("%s:%d: expecting \"key: value\"", path, lineNumber)
+		}
+		name = strings.TrimSpace(name)
+		value, err = parseConfigValue(value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("%s:%d: unknown option %q", path, lineNumber, name)
+		}
+		if set[name] {
+			continue
+		}
+		err = flag.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid value %q for %q: %w", path, lineNumber, value, name, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	return nil
+}
+
+// Parse a YAML scalar value, removing quotes and trailing comments
+func parseConfigValue(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := strings.LastIndex(value, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, `'`):
+		end := strings.LastIndex(value, `'`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strings.ReplaceAll(value[1:end], `''`, `'`), nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// crashGrace is how long after a download finishes a crash is still
+// blamed on it, as the download may fail before the crash is noticed
+const crashGrace = 5 * time.Second
+
+// crashTracker records which photos were being downloaded when the
+// browser crashed so a photo which always crashes it can be skipped
+type crashTracker struct {
+	mu      sync.Mutex
+	current string         // photo being downloaded or ""
+	last    string         // last photo downloaded
+	lastEnd time.Time      // when the last download finished
+	crashes map[string]int // number of crashes for each photo
+}
+
+// Set the photo being downloaded, "" for none
+func (c *crashTracker) setCurrent(photoID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if photoID == "" && c.current != "" {
+		c.last, c.lastEnd = c.current, time.Now()
+	}
+	c.current = photoID
+}
+
+// Record a browser crash against the photo being downloaded, if any
+func (c *crashTracker) crashed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	photoID := c.current
+	if photoID == "" && time.Since(c.lastEnd) < crashGrace {
+		photoID = c.last
+	}
+	if photoID == "" {
+		return
+	}
+	if c.crashes == nil {
+		c.crashes = map[string]int{}
+	}
+	c.crashes[photoID]++
+	slog.Error("Browser crashed while downloading photo", "id", photoID, "crashes", c.crashes[photoID])
+	if *maxPhotoCrashes > 0 && c.crashes[photoID] == *maxPhotoCrashes {
+		slog.Error("Photo keeps crashing the browser - it will be skipped", "id", photoID)
+	}
+}
+
+// Returns an error if photoID has crashed the browser -max-photo-crashes times
+func (c *crashTracker) check(photoID string) error {
+	if *maxPhotoCrashes <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.crashes[photoID] >= *maxPhotoCrashes {
+		return fmt.Errorf("photo crashed the browser %d times so it is being skipped: %w", c.crashes[photoID], errKindPhotoCrash)
+	}
+	return nil
+}
+
+// Returns the IDs of the photos being skipped, sorted
+func (c *crashTracker) skipped() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	skipped := []string{}
+	for photoID, crashes := range c.crashes {
+		if *maxPhotoCrashes > 0 && crashes >= *maxPhotoCrashes {
+			skipped = append(skipped, photoID)
+		}
+	}
+	sort.Strings(skipped)
+	return skipped
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+const (
+	diagnosticsTimeout    = 10 * time.Second // max time to spend reading the browser state
+	errorScreenshotPrefix = "error-"         // file name prefix for screenshots of failed downloads
+)
+
+// Dump the browser diagnostics whenever one of the dumpSignals is received
+func (g *Gphotos) handleDumpSignals() {
+	if len(dumpSignals) == 0 {
+		return
+	}
+	dump := make(chan os.Signal, 1)
+	signal.Notify(dump, dumpSignals...)
+	go func() {
+		for sig := range dump {
+			slog.Info("Signal received - dumping diagnostics", "signal", sig)
+			g.dumpDiagnostics()
+		}
+	}()
+}
+
+// Save the stacks of all the goroutines, log the state of the browser
+// and save a screenshot of the page
+//
+// This doesn't take the download lock so it can be used to see what
+// the browser is doing when a download is stuck. The goroutine stacks
+// show where gphotosdl itself is stuck, like SIGQUIT does but without
+// exiting.
+func (g *Gphotos) dumpDiagnostics() {
+	now := time.Now().Format("20060102-150405")
+	path, err := saveGoroutines(fmt.Sprintf("goroutines-%s.txt", now))
+	if err != nil {
+		slog.Error("Failed to save goroutine stacks", "err", err)
+	} else {
+		slog.Info("Saved goroutine stacks", "path", path)
+	}
+
+	locked := !g.mu.TryLock()
+	if !locked {
+		g.mu.Unlock()
+	}
+	slog.Info("Download lock", "locked", locked)
+
+	page := g.page.Timeout(diagnosticsTimeout)
+	info, err := page.Info()
+	if err != nil {
+		slog.Error("Failed to read page info", "err", err)
+	} else {
+		slog.Info("Page", "url", info.URL, "title", info.Title)
+	}
+
+	path, err = g.saveScreenshot(fmt.Sprintf("diagnostics-%s.png", now))
+	if err != nil {
+		slog.Error("Failed to save screenshot", "err", err)
+		return
+	}
+	slog.Info("Saved screenshot", "path", path)
+}
+
+// Returns the directory in the config directory which diagnostics
+// files are saved in, making it if necessary
+//
+// This isn't the download directory so they survive gphotosdl exiting
+// and aren't removed by -max-file-age.
+func diagnosticsDir() (string, error) {
+	dir := filepath.Join(configRoot, "diagnostics")
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("failed to make diagnostics directory: %w", err)
+	}
+	return dir, nil
+}
+
+// Save the stacks of all the goroutines to name in the diagnostics
+// directory returning its path
+func saveGoroutines(name string) (string, error) {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	dir, err := diagnosticsDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	err = os.WriteFile(path, buf, 0600)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Save a screenshot of the page to name in the diagnostics directory
+// returning its path
+func (g *Gphotos) saveScreenshot(name string) (string, error) {
+	dir, err := diagnosticsDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	err = g.writeScreenshot(path)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Save a screenshot of the page to path
+func (g *Gphotos) writeScreenshot(path string) error {
+	img, err := g.page.Timeout(diagnosticsTimeout).Screenshot(false, &proto.PageCaptureScreenshot{})
+	if err != nil {
+		return fmt.Errorf("failed to take screenshot: %w", err)
+	}
+	return os.WriteFile(path, img, 0600)
+}
+
+// Save a screenshot of the page after the download of photoID failed
+//
+// Only the newest -max-screenshots are kept so these can't fill the
+// disk.
+func (g *Gphotos) errorScreenshot(photoID string) {
+	name := fmt.Sprintf("%s%s-%s.png", errorScreenshotPrefix, time.Now().Format("20060102-150405.000"), pagePhotoID(photoID))
+	path, err := g.saveScreenshot(name)
+	if err != nil {
+		slog.Error("Failed to save error screenshot", "id", photoID, "err", err)
+		return
+	}
+	slog.Info("Saved error screenshot", "id", photoID, "path", path)
+
+	// The timestamp in the name means these sort oldest first
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(path), errorScreenshotPrefix+"*.png"))
+	if err != nil {
+		slog.Error("Failed to list error screenshots", "err", err)
+		return
+	}
+	sort.Strings(paths)
+	for len(paths) > max(*maxScreenshots, 0) {
+		err = os.Remove(paths[0])
+		if err == nil {
+			slog.Debug("Removed old error screenshot", "path", paths[0])
+		} else {
+			slog.Error("Failed to remove old error screenshot", "path", paths[0], "err", err)
+		}
+		paths = paths[1:]
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Find the original of photoID in the browser for -direct, returning
+// the request to fetch it with
+//
+// Only this needs the browser, so the next download can start while
+// the original is fetched.
+func (g *Gphotos) findOriginal(photoID string, d *Downloaded) (*http.Request, error) {
+	var req *http.Request
+	err := g.runDownload(photoID, d, func() error {
+		g.throttle.wait()
+		d.timings.mark("throttle")
+		err := g.preparePhoto(photoID, d)
+		if err == nil {
+			err = g.checkActivePhoto(photoID, d.RealID)
+		}
+		if err == nil {
+			req, err = g.originalRequest(photoID)
+		}
+		if err != nil {
+			g.finishDownload(photoID, d, err)
+		}
+		return err
+	})
+	return req, err
+}
+
+// Download photoID for -direct fetch by finding the original in the
+// browser then fetching it outside the browser
+func (g *Gphotos) downloadDirect(photoID string, d *Downloaded) error {
+	req, err := g.findOriginal(photoID, d)
+	if err != nil {
+		return err
+	}
+	err = fetchMedia(req, d)
+	// Not finishDownload as the browser has moved on to another photo
+	g.throttle.record(err)
+	g.errors.add(photoID, err)
+	g.stats.record(d, err)
+	return err
+}
+
+// Redirect the client to the original of photoID for -direct redirect
+//
+// The client fetches the file straight from Google so it doesn't pass
+// through gphotosdl at all.
+func (g *Gphotos) redirectToOriginal(w http.ResponseWriter, r *http.Request, photoID, requestID string) {
+	start := time.Now()
+	d := &Downloaded{}
+	req, err := g.findOriginal(photoID, d)
+	if err != nil {
+		slog.Error("Finding original failed", "id", photoID, "err", err)
+		logTimings(requestID, photoID, d)
+		writeError(w, photoID, err)
+		return
+	}
+	g.throttle.record(nil)
+	if d.RealID != "" {
+		w.Header().Set("X-Real-Photo-ID", d.RealID)
+	}
+	slog.Info("Redirecting to original", "id", photoID, "duration", time.Since(start))
+	logTimings(requestID, photoID, d)
+	http.Redirect(w, r, req.URL.String(), http.StatusFound)
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+// diskFree returns the number of bytes available to us on the disk
+// containing path
+func diskFree(path string) (int64, error) {
+	return 0, errDiskFreeUnsupported
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"syscall"
+)
+
+// diskFree returns the number of bytes available to us on the disk
+// containing path
+func diskFree(path string) (int64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil //nolint:unconvert // types differ between OSes
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The photos served by the mock in these tests
+func testPhotos() map[string]*mockPhoto {
+	return map[string]*mockPhoto{
+		"photo1":    {realID: "real1", name: "IMG_0001.JPG", mimeType: "image/jpeg", data: []byte("jpeg data")},
+		"large":     {realID: "real2", name: "IMG_0002.png", mimeType: "image/png", data: bytes.Repeat([]byte("png data"), 1<<17)},
+		"limited":   {realID: "real3", status: http.StatusTooManyRequests},
+		"menu":      {realID: "real4", name: "menu.png", mimeType: "image/png", data: []byte("png data"), noKey: true},
+		"stuck":     {realID: "real5", name: "stuck.jpg", mimeType: "image/jpeg", data: []byte("jpeg data"), noKey: true, noStart: true},
+		"truncated": {realID: "real6", name: "short.jpg", mimeType: "image/jpeg", data: []byte("not all of it"), truncate: true},
+	}
+}
+
+// Check the download of photoID succeeds and matches the mock
+func checkDownload(t *testing.T, g *Gphotos, photoID string, want *mockPhoto) *Downloaded {
+	t.Helper()
+	d, err := g.Download(photoID)
+	if err != nil {
+		t.Fatalf("download of %q failed: %v", photoID, err)
+	}
+	got, err := os.ReadFile(d.Path)
+	if err != nil {
+		t.Fatalf("failed to read download: %v", err)
+	}
+	if !bytes.Equal(got, want.data) {
+		t.Errorf("downloaded %d bytes which don't match the %d served", len(got), len(want.data))
+	}
+	if d.Size != int64(len(want.data)) {
+		t.Errorf("want size %d, got %d", len(want.data), d.Size)
+	}
+	if d.Name != want.name {
+		t.Errorf("want name %q, got %q", want.name, d.Name)
+	}
+	if d.RealID != want.realID {
+		t.Errorf("want real ID %q, got %q", want.realID, d.RealID)
+	}
+	// http.ServeFile picks the Content-Type from the extension
+	if mimeType, _, _ := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(d.Path))); mimeType != want.mimeType {
+		t.Errorf("want MIME type %q from the extension of %q, got %q", want.mimeType, d.Path, mimeType)
+	}
+	return d
+}
+
+func TestDownload(t *testing.T) {
+	photos := testPhotos()
+	m := newMockPhotos(t, photos)
+	g := newTestGphotos(t, m)
+
+	checkDownload(t, g, "photo1", photos["photo1"])
+	checkDownload(t, g, "large", photos["large"])
+
+	// Real IDs work after lr/photo isn't found
+	checkDownload(t, g, "real1", photos["photo1"])
+}
+
+func TestDownloadRealIDs(t *testing.T) {
+	photos := testPhotos()
+	m := newMockPhotos(t, photos)
+	g := newTestGphotos(t, m)
+	setVar(t, realIDs, true)
+
+	checkDownload(t, g, "real1", photos["photo1"])
+	if hits := m.lrHits.Load(); hits != 0 {
+		t.Errorf("want no requests for lr/photo with -real-ids, got %d", hits)
+	}
+
+	// Other IDs still work
+	checkDownload(t, g, "photo1", photos["photo1"])
+}
+
+func TestDownloadMenu(t *testing.T) {
+	photos := testPhotos()
+	m := newMockPhotos(t, photos)
+	g := newTestGphotos(t, m)
+
+	checkDownload(t, g, "menu", photos["menu"])
+}
+
+func TestDownloadNotFound(t *testing.T) {
+	m := newMockPhotos(t, testPhotos())
+	g := newTestGphotos(t, m)
+
+	_, err := g.Download("missing")
+	if !errors.Is(err, upstreamError(http.StatusNotFound)) {
+		t.Fatalf("want 404 from Google, got %v", err)
+	}
+	if kindOf(err) != errKindUpstream {
+		t.Errorf("want %v, got %v", errKindUpstream, kindOf(err))
+	}
+}
+
+func TestDownloadRateLimited(t *testing.T) {
+	m := newMockPhotos(t, testPhotos())
+	g := newTestGphotos(t, m)
+
+	_, err := g.Download("limited")
+	if !errors.Is(err, upstreamError(http.StatusTooManyRequests)) {
+		t.Fatalf("want 429 from Google, got %v", err)
+	}
+	if g.throttle.Delay() <= 0 {
+		t.Errorf("want the throttle to slow down after a 429")
+	}
+}
+
+func TestDownloadNotStarted(t *testing.T) {
+	m := newMockPhotos(t, testPhotos())
+	g := newTestGphotos(t, m)
+
+	_, err := g.Download("stuck")
+	if !errors.Is(err, errNoDownload) {
+		t.Fatalf("want %v, got %v", errNoDownload, err)
+	}
+	if kindOf(err) != errKindTimeout {
+		t.Errorf("want %v, got %v", errKindTimeout, kindOf(err))
+	}
+}
+
+func TestDownloadTruncated(t *testing.T) {
+	m := newMockPhotos(t, testPhotos())
+	g := newTestGphotos(t, m)
+
+	d, err := g.Download("truncated")
+	if err == nil {
+		t.Fatalf("want an error for a truncated download, got %q", d.Path)
+	}
+	// A short file mustn't be mistaken for the photo
+	paths, _ := filepath.Glob(filepath.Join(downloadDir, "*.jpg"))
+	if len(paths) != 0 {
+		t.Errorf("want no completed downloads, got %q", paths)
+	}
+}
+
+func TestDownloadFetchFallback(t *testing.T) {
+	photos := testPhotos()
+	m := newMockPhotos(t, photos)
+	g := newTestGphotos(t, m)
+	setVar(t, fetchFallback, true)
+
+	checkDownload(t, g, "stuck", photos["stuck"])
+}
+
+func TestDownloadDirect(t *testing.T) {
+	photos := testPhotos()
+	m := newMockPhotos(t, photos)
+	g := newTestGphotos(t, m)
+	setVar(t, directMode, "fetch")
+
+	checkDownload(t, g, "photo1", photos["photo1"])
+	checkDownload(t, g, "stuck", photos["stuck"])
+}
+
+func TestRedirectToOriginal(t *testing.T) {
+	m := newMockPhotos(t, testPhotos())
+	g := newTestGphotos(t, m)
+
+	w := httptest.NewRecorder()
+	g.redirectToOriginal(w, httptest.NewRequest(http.MethodGet, "/id/photo1", nil), "photo1", "1")
+	if w.Code != http.StatusFound {
+		t.Fatalf("want status %d, got %d: %s", http.StatusFound, w.Code, w.Body)
+	}
+	want := m.URL + "/media/real1=d?googleusercontent.com"
+	if got := w.Header().Get("Location"); got != want {
+		t.Errorf("want redirect to %q, got %q", want, got)
+	}
+}
+
+func TestOriginalMediaURL(t *testing.T) {
+	for _, test := range []struct {
+		in    string
+		video bool
+		want  string
+	}{
+		{"https://lh3.googleusercontent.com/pw/AP1Gcz=w1200-h800-no", false, "https://lh3.googleusercontent.com/pw/AP1Gcz=d"},
+		{"https://lh3.googleusercontent.com/pw/AP1Gcz=w1200-h800-no?authuser=1", false, "https://lh3.googleusercontent.com/pw/AP1Gcz=d?authuser=1"},
+		{"https://lh3.googleusercontent.com/pw/AP1Gcz=m18", true, "https://lh3.googleusercontent.com/pw/AP1Gcz=dv"},
+		{"https://lh3.googleusercontent.com/pw/AP1Gcz", false, "https://lh3.googleusercontent.com/pw/AP1Gcz=d"},
+	} {
+		got, err := originalMediaURL(test.in, test.video)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.in, err)
+		} else if got != test.want {
+			t.Errorf("%q: want %q, got %q", test.in, test.want, got)
+		}
+	}
+}
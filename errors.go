@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorKind is a kind of error returned by the proxy
+//
+// Every error sent to a client is one of errorKinds so tools using
+// gphotosdl can branch on the code or status. Errors are tagged with
+// their kind by wrapping it, eg fmt.Errorf("...: %w", errKindTimeout).
+// Don't change the code or status of an existing kind.
+type ErrorKind struct {
+	Code        string `json:"code"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+func (k *ErrorKind) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", k.Code, k.Status)
+}
+
+// The kinds of error returned by the proxy
+var (
+	errKindBadRequest  = &ErrorKind{"bad_request", http.StatusBadRequest, "The request was invalid, eg an unknown parameter. Don't retry."}
+	errKindBadAPIKey   = &ErrorKind{"bad_api_key", http.StatusUnauthorized, "The -api-key wasn't supplied or was wrong."}
+	errKindNotAuth     = &ErrorKind{"not_authenticated", http.StatusUnauthorized, "The browser isn't logged in to the right Google account. Re-run with -login."}
+	errKindNotFound    = &ErrorKind{"not_found", http.StatusNotFound, "The part of the photo asked for doesn't exist."}
+	errKindPhotoCrash  = &ErrorKind{"photo_crashes_browser", http.StatusUnprocessableEntity, "The photo crashed the browser -max-photo-crashes times so is skipped. Don't retry."}
+	errKindTooLarge    = &ErrorKind{"too_large", http.StatusRequestEntityTooLarge, "The file is bigger than -max-file-size."}
+	errKindRateLimited = &ErrorKind{"rate_limited", http.StatusTooManyRequests, "More downloads were asked for than -max-per-hour allows. Retry after the Retry-After header."}
+	errKindInternal    = &ErrorKind{"internal", http.StatusInternalServerError, "Something went wrong in gphotosdl or the browser. May be retried."}
+	errKindNotImpl     = &ErrorKind{"not_supported", http.StatusNotImplemented, "The request isn't supported with the current options."}
+	errKindUpstream    = &ErrorKind{"upstream_error", http.StatusBadGateway, "Google returned an error status, given in the X-Upstream-Status header and upstream_status."}
+	errKindErrorPage   = &ErrorKind{"google_error_page", http.StatusBadGateway, "Google showed an error page instead of the photo."}
+	errKindBadPage     = &ErrorKind{"unexpected_page", http.StatusBadGateway, "The Google page didn't have what was expected, probably because Google changed it."}
+	errKindQueueFull   = &ErrorKind{"queue_full", http.StatusServiceUnavailable, "Too many downloads are waiting. Retry later."}
+	errKindStarting    = &ErrorKind{"browser_starting", http.StatusServiceUnavailable, "The browser is starting and checking it is logged in. Retry after the Retry-After header."}
+	errKindRestarting  = &ErrorKind{"browser_restarting", http.StatusServiceUnavailable, "The browser is restarting. Retry later."}
+	errKindDegraded    = &ErrorKind{"browser_failed", http.StatusServiceUnavailable, "The browser failed to restart too many times. Restart gphotosdl."}
+	errKindResetFailed = &ErrorKind{"reset_failed", http.StatusServiceUnavailable, "POST /admin/reset-page couldn't get the page back to Google Photos. Restart the browser."}
+	errKindTimeout     = &ErrorKind{"timeout", http.StatusGatewayTimeout, "The browser took too long, eg for the photo page to load or the download to start. May be retried."}
+	errKindDiskFull    = &ErrorKind{"disk_full", http.StatusInsufficientStorage, "There is less than -min-free-space free for downloads."}
+)
+
+// errorKinds lists every kind of error the proxy returns
+var errorKinds = []*ErrorKind{
+	errKindBadRequest,
+	errKindBadAPIKey,
+	errKindNotAuth,
+	errKindNotFound,
+	errKindTooLarge,
+	errKindPhotoCrash,
+	errKindRateLimited,
+	errKindInternal,
+	errKindNotImpl,
+	errKindUpstream,
+	errKindErrorPage,
+	errKindBadPage,
+	errKindQueueFull,
+	errKindStarting,
+	errKindRestarting,
+	errKindDegraded,
+	errKindResetFailed,
+	errKindTimeout,
+	errKindDiskFull,
+}
+
+// retryAfter is how long clients should wait before retrying errors
+// of these kinds, sent in the Retry-After header
+var retryAfter = map[*ErrorKind]time.Duration{
+	errKindStarting: 10 * time.Second,
+}
+
+// retryAfterError suggests how long to wait before retrying err,
+// overriding retryAfter for its kind
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+// Returns err with a hint to retry after the duration given
+func withRetryAfter(err error, after time.Duration) error {
+	return retryAfterError{err: err, after: after}
+}
+
+func (r retryAfterError) Error() string {
+	return r.err.Error()
+}
+
+func (r retryAfterError) Unwrap() error {
+	return r.err
+}
+
+// retryAfterOf returns how long to wait before retrying err and
+// whether there is a suggestion at all
+func retryAfterOf(err error, kind *ErrorKind) (time.Duration, bool) {
+	var r retryAfterError
+	if errors.As(err, &r) {
+		return r.after, true
+	}
+	after, ok := retryAfter[kind]
+	return after, ok
+}
+
+// kindOf returns the ErrorKind inside err, or errKindInternal if it
+// doesn't have one
+func kindOf(err error) *ErrorKind {
+	var k *ErrorKind
+	if errors.As(err, &k) {
+		return k
+	}
+	return errKindInternal
+}
+
+// statusFor returns the HTTP status to send to the client for err
+//
+// This is the only place statuses for errors are decided.
+func statusFor(err error) int {
+	return kindOf(err).Status
+}
+
+// upstreamError wraps an HTTP status code returned by Google
+//
+// These are reported to the client as errKindUpstream so they can be
+// told apart from errors in the proxy itself.
+type upstreamError int
+
+func (u upstreamError) Error() string {
+	return fmt.Sprintf("google returned HTTP Error %d", u)
+}
+
+func (u upstreamError) Unwrap() error {
+	return errKindUpstream
+}
+
+// upstreamStatusOf returns the status from the upstreamError inside
+// err, or 0 if it doesn't have one
+func upstreamStatusOf(err error) int {
+	var u upstreamError
+	if errors.As(err, &u) {
+		return int(u)
+	}
+	return 0
+}
+
+// errorResponse is the JSON body sent to the client on errors
+type errorResponse struct {
+	Error          string `json:"error"`
+	Code           string `json:"code"`
+	ID             string `json:"id"`
+	Status         int    `json:"status"`
+	UpstreamStatus int    `json:"upstream_status,omitempty"`
+}
+
+// Write err to the client as JSON with the status and code from its
+// ErrorKind
+//
+// If Google returned the error then its status is sent in the
+// X-Upstream-Status header.
+func writeError(w http.ResponseWriter, id string, err error) {
+	kind := kindOf(err)
+	upstream := upstreamStatusOf(err)
+	if upstream != 0 {
+		w.Header().Set("X-Upstream-Status", strconv.Itoa(upstream))
+	}
+	if after, ok := retryAfterOf(err, kind); ok {
+		// Round up so clients don't come back too early
+		w.Header().Set("Retry-After", strconv.Itoa(int((after+time.Second-1)/time.Second)))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(kind.Status)
+	err = json.NewEncoder(w).Encode(errorResponse{
+		Error:          err.Error(),
+		Code:           kind.Code,
+		ID:             id,
+		Status:         kind.Status,
+		UpstreamStatus: upstream,
+	})
+	if err != nil {
+		slog.Debug("Failed to write error response", "id", id, "err", err)
+	}
+}
+
+// Serve the list of error kinds as JSON
+func getErrorKinds(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(errorKinds)
+	if err != nil {
+		slog.Debug("Failed to write error kinds response", "err", err)
+	}
+}
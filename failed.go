@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Keep the artifacts of a failed download of photoID for -keep-failed
+//
+// Each failure gets its own directory in the "failed" directory of the
+// config directory, so they survive gphotosdl exiting, holding the
+// error, a screenshot of the page and whatever was downloaded. Once
+// these take up more than -keep-failed-size the oldest are removed.
+func (g *Gphotos) keepFailedDownload(photoID string, d *Downloaded, downloadErr error) {
+	root := filepath.Join(configRoot, "failed")
+	dir := filepath.Join(root, fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405.000"), pagePhotoID(photoID)))
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		slog.Error("Failed to make directory for failed download", "id", photoID, "err", err)
+		return
+	}
+
+	info := fmt.Sprintf("id: %s\nreal_id: %s\nname: %s\nerror: %v\n", photoID, d.RealID, d.Name, downloadErr)
+	if page, err := g.page.Timeout(diagnosticsTimeout).Info(); err == nil {
+		info += fmt.Sprintf("url: %s\ntitle: %s\n", page.URL, page.Title)
+	}
+	err = os.WriteFile(filepath.Join(dir, "error.txt"), []byte(info), 0600)
+	if err != nil {
+		slog.Error("Failed to save error of failed download", "id", photoID, "err", err)
+	}
+
+	err = g.writeScreenshot(filepath.Join(dir, "screenshot.png"))
+	if err != nil {
+		slog.Error("Failed to save screenshot of failed download", "id", photoID, "err", err)
+	}
+
+	// Copy rather than move the download as the caller still owns it
+	if d.Path != "" {
+		if _, err := os.Stat(d.Path); err == nil {
+			err = copyFile(filepath.Join(dir, filepath.Base(d.Path)), d.Path)
+			if err != nil {
+				slog.Error("Failed to keep file of failed download", "id", photoID, "err", err)
+			}
+		}
+	}
+	slog.Info("Kept failed download", "id", photoID, "dir", dir)
+	pruneFailedDownloads(root, *keepFailedSize<<20)
+}
+
+// Remove the oldest failed downloads in root until they take up no
+// more than limit bytes
+func pruneFailedDownloads(root string, limit int64) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		slog.Error("Failed to list failed downloads", "err", err)
+		return
+	}
+	// The timestamp in the name means these sort oldest first
+	type failure struct {
+		dir  string
+		size int64
+	}
+	var (
+		failures []failure
+		total    int64
+	)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		size := dirSize(dir)
+		failures = append(failures, failure{dir: dir, size: size})
+		total += size
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].dir < failures[j].dir })
+	// Always keep the newest even if it is over the limit on its own
+	for len(failures) > 1 && total > limit {
+		err = os.RemoveAll(failures[0].dir)
+		if err != nil {
+			slog.Error("Failed to remove old failed download", "dir", failures[0].dir, "err", err)
+			return
+		}
+		slog.Debug("Removed old failed download", "dir", failures[0].dir, "size", failures[0].size)
+		total -= failures[0].size
+		failures = failures[1:]
+	}
+}
+
+// Returns the total size of the files in dir
+func dirSize(dir string) (size int64) {
+	_ = filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if fi, err := entry.Info(); err == nil {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Finds the URL of the photo or video showing on the page
+//
+// This is the biggest googleusercontent.com image the page has loaded,
+// or the video if there is one.
+const mediaURLScript = `() => {
+	const video = document.querySelector('video[src*="googleusercontent.com"]');
+	if (video) {
+		return {url: video.src, video: true};
+	}
+	let best = null;
+	for (const img of document.querySelectorAll('img[src*="googleusercontent.com"]')) {
+		if (!best || img.naturalWidth * img.naturalHeight > best.naturalWidth * best.naturalHeight) {
+			best = img;
+		}
+	}
+	return {url: best ? best.src : "", video: false};
+}`
+
+// errNoMediaURL is returned if the page doesn't show where the photo is
+var errNoMediaURL = fmt.Errorf("couldn't find the photo's media URL on the page: %w", errKindBadPage)
+
+// Returns the URL of the original file for the googleusercontent.com
+// URL u of a resized photo or video
+//
+// The size options come after the last "=" in the path, eg
+// ".../AF1Qip...=w1200-h800-no". "=d" asks for the original photo and
+// "=dv" for the original video.
+func originalMediaURL(u string, video bool) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", fmt.Errorf("bad media URL %q: %v: %w", u, err, errKindBadPage)
+	}
+	if i := strings.LastIndex(parsed.Path, "="); i >= 0 && !strings.Contains(parsed.Path[i:], "/") {
+		parsed.Path = parsed.Path[:i]
+	}
+	if video {
+		parsed.Path += "=dv"
+	} else {
+		parsed.Path += "=d"
+	}
+	parsed.RawPath = ""
+	return parsed.String(), nil
+}
+
+// Download the original of the photo showing on the page with the
+// browser's cookies, without using the browser's download
+//
+// This is used by -fetch-fallback when the browser won't start the
+// download.
+func (g *Gphotos) fetchOriginal(photoID string, d *Downloaded) error {
+	req, err := g.originalRequest(photoID)
+	if err != nil {
+		return err
+	}
+	return fetchMedia(req, d)
+}
+
+// Make the request to fetch the original of the photo showing on the
+// page with the browser's cookies
+//
+// This must be called with the lock held, but the request doesn't need
+// the browser so can be done after it is released.
+func (g *Gphotos) originalRequest(photoID string) (*http.Request, error) {
+	res, err := g.page.Timeout(*waitTimeout).Eval(mediaURLScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the media URL: %w", err)
+	}
+	mediaURL := res.Value.Get("url").Str()
+	if mediaURL == "" {
+		return nil, errNoMediaURL
+	}
+	u, err := originalMediaURL(mediaURL, res.Value.Get("video").Bool())
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("Found original media URL", "id", photoID, "url", u)
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make fetch request: %w", err)
+	}
+	cookies, err := g.page.Cookies([]string{u})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read browser cookies: %w", err)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	version, err := proto.BrowserGetVersion{}.Call(g.browser)
+	if err == nil {
+		req.Header.Set("User-Agent", version.UserAgent)
+	}
+	if *userAgent != "" {
+		req.Header.Set("User-Agent", *userAgent)
+	}
+	return req, nil
+}
+
+// Fetch the file for req into the download directory, setting the path,
+// name and size in d
+func fetchMedia(req *http.Request, d *Downloaded) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: *waitTimeout,
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch failed: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch failed: %w", upstreamError(resp.StatusCode))
+	}
+	if resp.ContentLength > 0 {
+		err = checkFileSize(resp.ContentLength)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Use the original file name if Google sends it
+	name := ""
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = filepath.Base(params["filename"])
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		if exts, _ := mime.ExtensionsByType(resp.Header.Get("Content-Type")); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+
+	out, err := os.CreateTemp(downloadDir, "fetch-*"+ext)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	path := out.Name()
+	size, err := io.Copy(out, resp.Body)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && resp.ContentLength >= 0 && size != resp.ContentLength {
+		err = fmt.Errorf("fetch was truncated: got %d of %d bytes", size, resp.ContentLength)
+	}
+	if err == nil {
+		err = checkFileSize(size)
+	}
+	if err == nil {
+		err = setFileMode(path)
+	}
+	if err != nil {
+		removeErr := os.Remove(path)
+		if removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			slog.Error("Failed to remove failed fetch", "path", path, "err", removeErr)
+		}
+		return fmt.Errorf("fetch failed: %w", err)
+	}
+	d.timings.mark("fetch")
+	slog.Debug("Fetch successful", "size", size, "path", path, "name", name)
+
+	d.Path, d.Name, d.Size = path, name, size
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// Permissions set by -file-mode for downloaded files (0 to leave alone)
+var fileMode fs.FileMode
+
+// Parse the octal -file-mode given, eg "0640"
+func parseFileMode(s string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid -file-mode %q: use octal permissions, eg 0640", s)
+	}
+	if mode&0600 != 0600 {
+		return 0, fmt.Errorf("invalid -file-mode %q: the owner needs read and write permission", s)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// Returns the permissions for the download directory to go with the
+// file permissions - anyone who can read the files can list the
+// directory
+func dirMode(mode fs.FileMode) fs.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+// Set the permissions of the download directory from -file-mode
+func setDownloadDirMode() error {
+	if fileMode == 0 {
+		return nil
+	}
+	err := os.Chmod(downloadDir, dirMode(fileMode))
+	if err != nil {
+		return fmt.Errorf("failed to set download directory permissions: %w", err)
+	}
+	return nil
+}
+
+// Set the permissions of a downloaded file from -file-mode
+func setFileMode(path string) error {
+	if fileMode == 0 {
+		return nil
+	}
+	err := os.Chmod(path, fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to set download permissions: %w", err)
+	}
+	return nil
+}
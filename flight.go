@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// downloadFlights lets concurrent requests for the same photo share
+// one download
+//
+// The downloaded file is shared by all the requests so it must only be
+// removed once the last of them has called release.
+type downloadFlights struct {
+	mu      sync.Mutex
+	flights map[string]*downloadFlight
+}
+
+// downloadFlight is a download shared between requests
+type downloadFlight struct {
+	done   chan struct{} // closed when the download has finished
+	d      *Downloaded
+	err    error
+	refs   int  // number of requests using the file
+	failed bool // set if sending the file to any request failed
+}
+
+// Download key by calling fn, or wait for the download of key already
+// in progress and share its result
+//
+// If err is nil then release must be called with key when finished
+// with the file.
+func (f *downloadFlights) do(key string, fn func() (*Downloaded, error)) (d *Downloaded, shared bool, err error) {
+	f.mu.Lock()
+	if f.flights == nil {
+		f.flights = map[string]*downloadFlight{}
+	}
+	if flight, ok := f.flights[key]; ok {
+		flight.refs++
+		f.mu.Unlock()
+		<-flight.done
+		if flight.err != nil {
+			return flight.d, true, flight.err
+		}
+		slog.Debug("Sharing download with another request", "key", key)
+		return flight.d, true, nil
+	}
+	flight := &downloadFlight{
+		done: make(chan struct{}),
+		refs: 1,
+	}
+	f.flights[key] = flight
+	f.mu.Unlock()
+
+	flight.d, flight.err = fn()
+	if flight.err != nil {
+		// Nothing to share so the next request starts again
+		f.mu.Lock()
+		delete(f.flights, key)
+		f.mu.Unlock()
+	}
+	close(flight.done)
+	return flight.d, false, flight.err
+}
+
+// Finish using the file for key, recording whether sending it failed
+//
+// This returns last set if this was the last request using the file,
+// in which case the caller should dispose of it, and failed set if
+// sending it to any of the requests failed.
+func (f *downloadFlights) release(key string, sendFailed bool) (last, failed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	flight := f.flights[key]
+	if flight == nil {
+		return true, sendFailed
+	}
+	flight.failed = flight.failed || sendFailed
+	flight.refs--
+	if flight.refs > 0 {
+		return false, flight.failed
+	}
+	delete(f.flights, key)
+	return true, flight.failed
+}
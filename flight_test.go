@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDownloadFlightsShare(t *testing.T) {
+	var f downloadFlights
+	want := &Downloaded{Path: "/tmp/photo.jpg"}
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	type result struct {
+		d      *Downloaded
+		shared bool
+		err    error
+	}
+	first := make(chan result)
+	go func() {
+		d, shared, err := f.do("photo", func() (*Downloaded, error) {
+			close(started)
+			<-unblock
+			return want, nil
+		})
+		first <- result{d, shared, err}
+	}()
+	<-started
+
+	// A second request for the same photo waits for the first
+	second := make(chan result)
+	go func() {
+		d, shared, err := f.do("photo", func() (*Downloaded, error) {
+			t.Error("want the download shared, not done again")
+			return nil, nil
+		})
+		second <- result{d, shared, err}
+	}()
+	// Wait for the second request to join the flight
+	for {
+		f.mu.Lock()
+		refs := f.flights["photo"].refs
+		f.mu.Unlock()
+		if refs == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(unblock)
+
+	for _, test := range []struct {
+		got    result
+		shared bool
+	}{
+		{<-first, false},
+		{<-second, true},
+	} {
+		if test.got.err != nil || test.got.d != want || test.got.shared != test.shared {
+			t.Errorf("want (%v, %v, nil), got (%v, %v, %v)", want, test.shared, test.got.d, test.got.shared, test.got.err)
+		}
+	}
+
+	// Only the last release disposes of the file, and a failure to send
+	// to either request is reported
+	for i, test := range []struct {
+		sendFailed bool
+		wantLast   bool
+		wantFailed bool
+	}{
+		{true, false, true},
+		{false, true, true},
+		{false, true, false}, // nothing left to release
+	} {
+		last, failed := f.release("photo", test.sendFailed)
+		if last != test.wantLast || failed != test.wantFailed {
+			t.Errorf("release %d: want last %v failed %v, got last %v failed %v", i, test.wantLast, test.wantFailed, last, failed)
+		}
+	}
+}
+
+func TestDownloadFlightsError(t *testing.T) {
+	var f downloadFlights
+	errDownload := errors.New("download failed")
+	_, shared, err := f.do("photo", func() (*Downloaded, error) {
+		return nil, errDownload
+	})
+	if err != errDownload || shared {
+		t.Fatalf("want (false, %v), got (%v, %v)", errDownload, shared, err)
+	}
+	// A failed download isn't shared with the next request
+	calls := 0
+	_, shared, err = f.do("photo", func() (*Downloaded, error) {
+		calls++
+		return &Downloaded{}, nil
+	})
+	if err != nil || shared || calls != 1 {
+		t.Errorf("want a new download, got shared %v, %d calls, err %v", shared, calls, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"compress/gzip"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Compress the JSON and metrics responses of handler with gzip if the
+// client accepts it and -gzip is set
+//
+// This is only used on the routes which return JSON or metrics so
+// photos, which are compressed already, keep being sent with sendfile.
+// The decision is made when the handler writes the header, so
+// handlers don't need to know about it.
+func gzipHandler(handler http.HandlerFunc) http.HandlerFunc {
+	if !*gzipJSON {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			handler(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		handler(gw, r)
+	}
+}
+
+// Returns true if the Accept-Encoding header given allows gzip
+func acceptsGzip(acceptEncoding string) bool {
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(encoding, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		// gzip;q=0 means the client doesn't want gzip
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
+
+// Returns true if a response with contentType is worth compressing
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, "text/plain")
+}
+
+// gzipResponseWriter compresses the response if it is JSON or text
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer // set if the response is being compressed
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if code != http.StatusNoContent && code != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" &&
+		compressible(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush the compressed data so far to the client so streamed
+// responses like album listings keep arriving
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		err := w.gz.Flush()
+		if err != nil {
+			return
+		}
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Finish the compressed stream
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	err := w.gz.Close()
+	if err != nil {
+		slog.Debug("Failed to finish gzip response", "err", err)
+	}
+}
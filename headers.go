@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// headerFlag collects the repeatable -header KEY:VALUE flags
+type headerFlag struct {
+	header http.Header
+}
+
+func (f *headerFlag) String() string {
+	var pairs []string
+	for key, values := range f.header {
+		for _, value := range values {
+			pairs = append(pairs, key+":"+value)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Returns the headers like String but with the values hidden
+func (f *headerFlag) redacted() string {
+	keys := make([]string, 0, len(f.header))
+	for key := range f.header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		for range f.header[key] {
+			pairs = append(pairs, key+":REDACTED")
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set adds a KEY:VALUE header
+func (f *headerFlag) Set(s string) error {
+	key, value, found := strings.Cut(s, ":")
+	key = strings.TrimSpace(key)
+	if !found || key == "" || strings.ContainsAny(key, " \t\r\n") || strings.ContainsAny(value, "\r\n") {
+		return errors.New("header must be KEY:VALUE, eg \"Access-Control-Allow-Origin: *\"")
+	}
+	if f.header == nil {
+		f.header = http.Header{}
+	}
+	f.header.Add(key, strings.TrimSpace(value))
+	return nil
+}
+
+// Define a repeatable KEY:VALUE header flag like flag.String
+func headerVar(name, usage string) *headerFlag {
+	f := &headerFlag{}
+	flag.Var(f, name, usage)
+	return f
+}
+
+// Add the -header headers to every response from h
+func headersHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for key, values := range extraHeaders.header {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
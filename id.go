@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Google Photos IDs, eg AF1QipNJVLe7d5mOh-b4CzFAob1UW-6EpFd0HnCBT3c6
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Tidy up an ID from a client by removing surrounding white space and
+// slashes
+//
+// IDs are case sensitive so the case is left alone.
+func normalizeID(id string) string {
+	return strings.Trim(id, " \t\r\n/")
+}
+
+// Check an ID of the kind given (eg "photo") only has characters
+// Google uses in IDs
+//
+// The IDs are put on the end of URLs for the browser, so this stops
+// an ID with a "/", "?" or ".." in it taking the browser somewhere
+// else.
+func checkID(kind, id string) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("invalid %s ID %q: %w", kind, id, errKindBadRequest)
+	}
+	return nil
+}
+
+// Check a photo ID, which may be a shared photo ID made by
+// sharedPhotoID
+func checkPhotoID(photoID string) error {
+	if !isSharedPhotoID(photoID) {
+		return checkID("photo", photoID)
+	}
+	shareID, rest, _ := strings.Cut(photoID, "/photo/")
+	pageID, query, _ := strings.Cut(rest, "?")
+	err := checkID("share", shareID)
+	if err != nil {
+		return err
+	}
+	err = checkID("photo", pageID)
+	if err != nil {
+		return err
+	}
+	if query == "" {
+		return nil
+	}
+	// The key was escaped by sharedPhotoID so can only be the key
+	values, err := url.ParseQuery(query)
+	if err != nil || len(values) != 1 || len(values["key"]) != 1 || url.QueryEscape(values.Get("key")) != strings.TrimPrefix(query, "key=") {
+		return fmt.Errorf("invalid shared photo ID %q: %w", photoID, errKindBadRequest)
+	}
+	return nil
+}
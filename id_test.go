@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestNormalizeID(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"AF1QipNJVLe7d5mOh-b4CzFAob1UW-6EpFd0HnCBT3c6", "AF1QipNJVLe7d5mOh-b4CzFAob1UW-6EpFd0HnCBT3c6"},
+		{"AF1Qip/", "AF1Qip"},
+		{"/AF1Qip//", "AF1Qip"},
+		{" AF1Qip\t\r\n", "AF1Qip"},
+		{"AbCd", "AbCd"},
+		{"a/b", "a/b"},
+		{"", ""},
+		{" / ", ""},
+	} {
+		got := normalizeID(test.in)
+		if got != test.want {
+			t.Errorf("%q: want %q, got %q", test.in, test.want, got)
+		}
+	}
+}
+
+func TestCheckPhotoID(t *testing.T) {
+	for _, test := range []struct {
+		in    string
+		valid bool
+	}{
+		{"AF1QipNJVLe7d5mOh-b4CzFAob1UW-6EpFd0HnCBT3c6", true},
+		{"abc_DEF-123", true},
+		{"", false},
+		{"..", false},
+		{"abc/def", false},
+		{"abc?x=1", false},
+		{"abc%2F", false},
+		{"abc def", false},
+		{sharedPhotoID("share1", "photo1", ""), true},
+		{sharedPhotoID("share1", "photo1", "a key/with?odd=chars"), true},
+		{sharedPhotoID("share/1", "photo1", "key"), false},
+		{sharedPhotoID("share1", "photo..", "key"), false},
+		{"share1/photo/photo1?key=k&other=1", false},
+		{"share1/photo/photo1?notkey=k", false},
+		{"share1/photo/photo1?key=a/b", false},
+	} {
+		err := checkPhotoID(test.in)
+		if test.valid && err != nil {
+			t.Errorf("%q: want valid, got %v", test.in, err)
+		} else if !test.valid && kindOf(err) != errKindBadRequest {
+			t.Errorf("%q: want %v, got %v", test.in, errKindBadRequest, err)
+		}
+	}
+}
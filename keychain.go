@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/go-rod/rod/lib/launcher"
+)
+
+// What the browser logs when it can't decrypt the cookies in the
+// profile
+const decryptFailedLog = "Failed to decrypt token"
+
+// Warn once that the cookies can't be decrypted
+var warnDecryptFailed sync.Once
+
+// Set l to use the macOS Keychain or not as set by -mock-keychain
+//
+// On macOS the browser encrypts the cookies with a key from the
+// Keychain, or a fixed key if it uses the mock keychain. The login and
+// the proxy must use the same one or the login cookies can't be
+// decrypted. This makes no difference on other systems.
+func setKeychain(l *launcher.Launcher) *launcher.Launcher {
+	if *mockKeychain {
+		return l.Set("use-mock-keychain")
+	}
+	return l.Delete("use-mock-keychain")
+}
+
+// Arguments for running the -login browser with the same keychain as
+// setKeychain
+func keychainArgs() []string {
+	if *mockKeychain {
+		return []string{"--use-mock-keychain"}
+	}
+	return nil
+}
+
+// Check a line the browser logged for signs it couldn't decrypt the
+// login cookies
+func checkDecryptFailed(line string) {
+	if !strings.Contains(line, decryptFailedLog) {
+		return
+	}
+	warnDecryptFailed.Do(func() {
+		slog.Warn("The browser can't decrypt the login cookies. This happens on macOS if the profile was logged in using a different keychain - re-run with -login, using the same -mock-keychain setting as the proxy")
+	})
+}
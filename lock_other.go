@@ -0,0 +1,30 @@
+//go:build windows || plan9
+
+package main
+
+import "os"
+
+// Clear the profile lock left behind if gphotosdl was killed without
+// shutting down cleanly
+//
+// On Windows the lock is a file the browser holds open, so it goes
+// away with the browser and there is nothing to clear.
+func clearStaleLock() error {
+	return nil
+}
+
+// Stop recording this gphotosdl as the owner of the profile
+func releaseProfile() {}
+
+// Returns true if the process with pid may be a gphotosdl
+//
+// This only checks the process exists, so errs on the side of leaving
+// the directories of a process which has gone alone.
+func isGphotosdl(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
@@ -0,0 +1,141 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	orphanKillWait = 5 * time.Second        // how long to wait for an orphaned browser to die
+	orphanKillPoll = 100 * time.Millisecond // how often to check whether it has died
+)
+
+// Files Chrome creates in the user data directory to stop two browsers
+// using the same profile
+var singletonFiles = []string{"SingletonLock", "SingletonSocket", "SingletonCookie"}
+
+// Clear the profile lock left behind if gphotosdl was killed without
+// shutting down cleanly, and record this gphotosdl as the owner of the
+// profile
+//
+// The owner's PID is kept in ownerFile in the profile as the
+// browser's parent is the leakless wrapper rather than gphotosdl. If
+// that process is still a gphotosdl then the profile is in use.
+// Otherwise the SingletonLock, a symlink to "hostname-pid" of the
+// browser using the profile, is stale. It is removed, and if the
+// browser it points to was left running by a previous gphotosdl it is
+// killed first, otherwise the browser would refuse to start saying the
+// profile is in use.
+//
+// An error is returned if another gphotosdl is using the profile.
+func clearStaleLock() error {
+	if owner, ok := otherOwner(browserConfig); ok {
+		return fmt.Errorf("another %s is using the browser profile %q (pid %d) - stop it or use a different -config-dir", program, browserConfig, owner)
+	}
+	err := removeSingletonLock()
+	if err != nil {
+		return err
+	}
+	err = writeOwner(browserConfig)
+	if err != nil {
+		slog.Warn("Failed to record the owner of the browser profile", "err", err)
+	}
+	return nil
+}
+
+// Stop recording this gphotosdl as the owner of the profile
+func releaseProfile() {
+	err := removeOwner(browserConfig)
+	if err != nil {
+		slog.Error("Failed to remove browser profile owner", "err", err)
+	}
+}
+
+// Remove the SingletonLock left by a browser no gphotosdl owns,
+// killing the browser if it is still running
+func removeSingletonLock() error {
+	lock := filepath.Join(browserConfig, "SingletonLock")
+	target, err := os.Readlink(lock)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		slog.Debug("Failed to read browser profile lock", "path", lock, "err", err)
+		return nil
+	}
+	i := strings.LastIndex(target, "-")
+	pid, err := strconv.Atoi(target[i+1:])
+	if i < 0 || err != nil {
+		slog.Warn("Didn't understand browser profile lock - leaving it alone", "path", lock, "target", target)
+		return nil
+	}
+	host := target[:i]
+	hostname, _ := os.Hostname()
+	if host != hostname {
+		slog.Warn("Browser profile is locked by another computer - leaving it alone", "path", lock, "host", host)
+		return nil
+	}
+	if processRunning(pid) && strings.Contains(processField(pid, "command"), "--user-data-dir="+browserConfig) {
+		slog.Warn("Killing browser left running by a previous gphotosdl", "pid", pid)
+		err = killProcess(pid)
+		if err != nil {
+			return fmt.Errorf("failed to kill orphaned browser using the profile: %w", err)
+		}
+	}
+	for _, name := range singletonFiles {
+		err = os.Remove(filepath.Join(browserConfig, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("Failed to remove stale browser profile lock", "name", name, "err", err)
+		}
+	}
+	slog.Info("Removed stale browser profile lock", "pid", pid)
+	return nil
+}
+
+// Returns true if the process with pid is a gphotosdl
+func isGphotosdl(pid int) bool {
+	return processRunning(pid) && strings.Contains(processField(pid, "command"), program)
+}
+
+// Returns true if the process with pid exists
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// Returns the field of the process with pid from ps or "" if it couldn't
+// be read
+func processField(pid int, field string) string {
+	out, err := exec.Command("ps", "-ww", "-o", field+"=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		slog.Debug("Failed to read process details", "pid", pid, "field", field, "err", err)
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Kill the process with pid and wait for it to go away
+func killProcess(pid int) error {
+	err := syscall.Kill(pid, syscall.SIGKILL)
+	if err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	deadline := time.Now().Add(orphanKillWait)
+	for processRunning(pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("process %d still running %v after being killed", pid, orphanKillWait)
+		}
+		time.Sleep(orphanKillPoll)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Start a process which is killed at the end of the test, returning
+// its PID
+func startProcess(t *testing.T, name string, args ...string) int {
+	t.Helper()
+	cmd := exec.Command(name, args...)
+	err := cmd.Start()
+	if err != nil {
+		t.Fatalf("failed to start %s: %v", name, err)
+	}
+	done := make(chan struct{})
+	go func() {
+		// Reap the process so it doesn't look like it is still running
+		_ = cmd.Wait()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		<-done
+	})
+	return cmd.Process.Pid
+}
+
+// Returns the PID of a process which has exited
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf("failed to run true: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestClearStaleLock(t *testing.T) {
+	hostname, _ := os.Hostname()
+	exited := exitedPID(t)
+	deadLock := hostname + "-" + strconv.Itoa(exited)
+	// ps shows the arguments after the script so these look like a
+	// gphotosdl and something else
+	owner := startProcess(t, "sh", "-c", "sleep 30; true", program)
+	other := startProcess(t, "sh", "-c", "sleep 30; true", "other")
+
+	for _, test := range []struct {
+		name     string
+		lock     string // target of SingletonLock or "" for none
+		orphan   bool   // set to point the lock at a browser left running
+		owner    int    // PID in ownerFile or 0 for none
+		wantErr  bool
+		wantLock bool // set if SingletonLock should be left alone
+	}{
+		{name: "no lock"},
+		{name: "browser exited", lock: deadLock},
+		{name: "browser left running", orphan: true},
+		{name: "other computer", lock: "otherhost-1", wantLock: true},
+		{name: "bad lock", lock: "nonsense", wantLock: true},
+		{name: "owner running", lock: deadLock, owner: owner, wantErr: true, wantLock: true},
+		{name: "owner exited", lock: deadLock, owner: exited},
+		{name: "owner PID reused", lock: deadLock, owner: other},
+		{name: "owner is us", lock: deadLock, owner: os.Getpid()},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			setVar(t, &browserConfig, dir)
+			lock := test.lock
+			orphan := 0
+			if test.orphan {
+				orphan = startProcess(t, "sh", "-c", "sleep 30; true", "--user-data-dir="+dir)
+				lock = hostname + "-" + strconv.Itoa(orphan)
+			}
+			if lock != "" {
+				err := os.Symlink(lock, filepath.Join(dir, "SingletonLock"))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.owner != 0 {
+				err := os.WriteFile(filepath.Join(dir, ownerFile), []byte(strconv.Itoa(test.owner)+"\n"), 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := clearStaleLock()
+			if test.wantErr != (err != nil) {
+				t.Fatalf("want error %v, got %v", test.wantErr, err)
+			}
+			_, err = os.Lstat(filepath.Join(dir, "SingletonLock"))
+			if gotLock := !errors.Is(err, fs.ErrNotExist); lock != "" && gotLock != test.wantLock {
+				t.Errorf("want lock left %v, got %v", test.wantLock, gotLock)
+			}
+			if orphan != 0 && processRunning(orphan) {
+				t.Errorf("want orphaned browser %d killed", orphan)
+			}
+			if want := os.Getpid(); !test.wantErr && readOwner(dir) != want {
+				t.Errorf("want owner %d recorded, got %d", want, readOwner(dir))
+			}
+		})
+	}
+}
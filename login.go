@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Log in using a headless browser controlled from another machine
+//
+// This is for servers with no display. The browser's remote debugging
+// port is forwarded over ssh and the login page is driven from
+// chrome://inspect in a browser on the user's desktop.
+func runRemoteLogin() error {
+	l := launcher.New().
+		Bin(browserPath).
+		UserDataDir(browserConfig).
+		RemoteDebuggingPort(*remoteLoginPort).
+		Logger(logger{})
+	l = setKeychain(l)
+	l = setHeadless(l)
+	url, err := l.Launch()
+	if err != nil {
+		return fmt.Errorf("browser launch: %w", err)
+	}
+	defer l.Kill()
+
+	browser := rod.New().ControlURL(url).Logger(logger{})
+	err = browser.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to browser: %w", err)
+	}
+	defer func() {
+		// Close the browser cleanly so the login cookies are saved
+		_ = browser.Close()
+	}()
+
+	page, err := browser.Page(proto.TargetCreateTarget{URL: gphotosURL})
+	if err != nil {
+		return fmt.Errorf("couldn't open gphotos URL: %w", err)
+	}
+
+	host, _ := os.Hostname()
+	slog.Info("Browser started for remote login - on your desktop machine:")
+	slog.Info(fmt.Sprintf("1. Run: ssh -L %d:localhost:%d %s", *remoteLoginPort, *remoteLoginPort, host))
+	slog.Info(fmt.Sprintf("2. Open chrome://inspect in Chrome, click \"Configure...\" and add localhost:%d", *remoteLoginPort))
+	slog.Info("3. Click \"inspect\" under the Google Photos page and log in using the window which appears")
+	slog.Info("Waiting for login to complete - press CTRL-C to abort")
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, exitSignals...)
+	defer signal.Stop(quit)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case sig := <-quit:
+			return fmt.Errorf("login aborted by signal %v", sig)
+		case <-ticker.C:
+		}
+		info, err := page.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read page info: %w", err)
+		}
+		if isAuthenticatedURL(info.URL) {
+			slog.Info("Logged in successfully")
+			return nil
+		}
+	}
+}
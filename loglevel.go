@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+)
+
+// logLevel is the current level of the logger which can be changed
+// while running
+var logLevel = new(slog.LevelVar)
+
+// Set the level of the logger
+func setLogLevel(level slog.Level) {
+	logLevel.Set(level)
+	if !*useJSON {
+		slog.SetLogLoggerLevel(level) // set log level of Default Handler
+	}
+}
+
+// Toggle the log level between DEBUG and INFO when a signal in
+// logLevelSignals is received
+func handleLogLevelSignals() {
+	if len(logLevelSignals) == 0 {
+		return
+	}
+	toggle := make(chan os.Signal, 1)
+	signal.Notify(toggle, logLevelSignals...)
+	go func() {
+		for sig := range toggle {
+			level := slog.LevelDebug
+			if logLevel.Level() <= slog.LevelDebug {
+				level = slog.LevelInfo
+			}
+			setLogLevel(level)
+			slog.Warn("Signal received - changed log level", "signal", sig, "level", level)
+		}
+	}()
+}
+
+// LogLevel is the body of the /loglevel endpoint
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// Serve the current log level as JSON
+func getLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(LogLevel{Level: logLevel.Level().String()})
+	if err != nil {
+		slog.Debug("Failed to write log level response", "err", err)
+	}
+}
+
+// Set the log level from the "level" query parameter, eg ?level=debug
+func postLogLevel(w http.ResponseWriter, r *http.Request) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(r.FormValue("level")))
+	if err != nil {
+		writeError(w, "", fmt.Errorf("bad level: use debug, info, warn or error: %w", errKindBadRequest))
+		return
+	}
+	setLogLevel(level)
+	slog.Warn("Changed log level", "level", level, "remote", r.RemoteAddr)
+	getLogLevel(w, r)
+}
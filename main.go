@@ -2,36 +2,54 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-rod/rod"
-	"github.com/go-rod/rod/lib/input"
 	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/launcher/flags"
 	"github.com/go-rod/rod/lib/proto"
 )
 
 const (
 	program       = "gphotosdl"
-	gphotosURL    = "https://photos.google.com/"
 	gphotoURLReal = "https://photos.google.com/photo/"
 	gphotoURL     = "https://photos.google.com/lr/photo/" // redirects to gphotosURLReal which uses a different ID
 	photoID       = "AF1QipNJVLe7d5mOh-b4CzFAob1UW-6EpFd0HnCBT3c6"
+
+	freeSpaceCheckInterval = time.Minute // how often to check free disk space in the background
+
+	// Web server limits
+	readHeaderTimeout = 30 * time.Second // max time for a client to send the request headers
+	maxHeaderBytes    = 64 << 10         // max size of the request headers
+	maxRequestBody    = 1 << 20          // max size of a request body
 )
 
+// Google Photos home page
+//
+// This is a variable so the tests can point it at a mock.
+var gphotosURL = "https://photos.google.com/"
+
 // Flags
 var (
 	debug   = flag.Bool("debug", false, "set to see debug messages")
@@ -39,15 +57,90 @@ var (
 	show    = flag.Bool("show", false, "set to show the browser (not headless)")
 	addr    = flag.String("addr", "localhost:8282", "address for the web server")
 	useJSON = flag.Bool("json", false, "log in JSON format")
+
+	waitMode           = flag.String("wait", "load", "page load strategy: load, stable or idle")
+	waitElement        = flag.String("wait-element", `img[src*="googleusercontent.com"], video`, "CSS selector to wait for before downloading (blank to disable)")
+	waitTimeout        = flag.Duration("wait-timeout", 30*time.Second, "max time to wait for the page to be ready")
+	userAgent          = flag.String("user-agent", "", "user agent for the browser to use instead of its default")
+	lang               = flag.String("lang", "en-US", "language for the browser UI and Accept-Language (blank for browser default)")
+	screenshotOnError  = flag.Bool("screenshot-on-error", false, "save a screenshot of the page to the download directory when a download fails")
+	maxScreenshots     = flag.Int("max-screenshots", 10, "max number of error screenshots to keep")
+	minFreeSpace       = flag.Int64("min-free-space", 100, "refuse downloads if there are fewer MiB than this free for the download directory (0 to disable)")
+	configDir          = flag.String("config-dir", "", "config directory (default gphotosdl in the user config directory)")
+	configFile         = flag.String("config", "", "path to an optional YAML config file of flag: value lines")
+	writeTimeout       = flag.Duration("write-timeout", 2*time.Hour, "max time to download and send a file to the client - make this generous for large videos over slow links")
+	maxQueue           = flag.Int("max-queue", 100, "max number of downloads to queue - more than this get a 503 error (0 for unlimited)")
+	pprofAddr          = flag.String("pprof", "", "address for the profiling web server, eg localhost:6060 (blank to disable)")
+	remoteLogin        = flag.Bool("remote-login", false, "set to log in on a headless server using a browser on another machine")
+	remoteLoginPort    = flag.Int("remote-login-port", 9222, "port for the browser remote debugging with -remote-login")
+	importProfilePath  = flag.String("import-profile", "", "path to a logged in Chrome profile to copy the login session from")
+	apiKey             = flag.String("api-key", "", "key required to use the status and admin endpoints (blank for none)")
+	accountIndex       = flag.Int("account-index", 0, "which account to use if more than one is signed in to the browser (0 is the first)")
+	retryCacheTTL      = flag.Duration("retry-cache-ttl", 5*time.Minute, "how long to keep a photo which failed to send to the client for a retry (0 to disable)")
+	streamDownloads    = flag.Bool("stream", false, "send downloads to the client while the browser is still downloading them")
+	windowSize         = flag.String("window-size", "", "browser window size as WIDTHxHEIGHT, eg 1920x1080 (blank for browser default)")
+	slowMotion         = flag.Duration("slow-motion", 0, "delay between browser actions to help with debugging, eg 100ms")
+	maxLifetime        = flag.Duration("max-lifetime", 0, "restart the browser after this long, eg 15m (0 to disable)")
+	once               = flag.String("once", "", "download the photo with this ID to -output then exit without running the web server")
+	output             = flag.String("output", "", "file to write the photo to with -once (default the original file name, - for stdout)")
+	photoURL           = flag.String("photo-url", gphotoURL, "URL prefix to fetch photos by ID")
+	realPhotoURL       = flag.String("real-photo-url", gphotoURLReal, "URL prefix of the photo page that -photo-url redirects to")
+	maxThrottle        = flag.Duration("max-throttle", 10*time.Minute, "max delay between downloads when Google is rate limiting (0 to disable throttling)")
+	resetMode          = flag.String("reset", "blank", "how to reset the page between photos: none, blank or page")
+	motionPart         = flag.String("motion", motionOriginal, "which part of motion photos to return by default: original, still or video")
+	maxFileSize        = flag.Int64("max-file-size", 0, "refuse to return files bigger than this many MiB (0 for no limit)")
+	noDisk             = flag.Bool("no-disk", false, "send downloads straight to the client without writing them to disk")
+	keepAlive          = flag.Bool("keep-alive", true, "reuse HTTP connections between requests")
+	idleTimeout        = flag.Duration("idle-timeout", 2*time.Minute, "max time to keep an idle HTTP connection open for reuse")
+	tcpKeepAlive       = flag.Duration("tcp-keep-alive", 15*time.Second, "interval between TCP keep-alive probes on client connections (negative to disable)")
+	downloadStartGrace = flag.Duration("download-start-grace", 5*time.Second, "time to wait for a download to start after Shift-D before trying again")
+	parkMode           = flag.String("park", "none", "where to leave the browser after downloads to save memory: none, home or blank")
+	parkAfter          = flag.Duration("park-after", 0, "how long to wait after the last download before applying -park")
+	settleDelay        = flag.Duration("settle", 250*time.Millisecond, "time to wait after the photo page is ready before starting the download")
+	maxRestarts        = flag.Int("max-restarts", 5, "give up restarting the browser after this many failed restarts in a row (0 to never give up)")
+	maxPhotoCrashes    = flag.Int("max-photo-crashes", 2, "skip a photo after it has crashed the browser this many times (0 to never skip)")
+	authTimeout        = flag.Duration("auth-timeout", time.Minute, "max time to wait at startup for the browser to show it is logged in")
+	authPoll           = flag.Duration("auth-poll", time.Second, "how often to check whether the browser is logged in at startup")
+	cacheControl       = flag.String("cache-control", "no-store", "Cache-Control header to send with photos (empty for none)")
+	headlessMode       = flag.String("headless", "new", "headless mode of the browser when not using -show: new or old")
+	fileModeFlag       = flag.String("file-mode", "", "octal permissions for downloaded files, eg 0640 - the download directory gets matching permissions (blank for default)")
+	managedBrowser     = flag.Bool("managed-browser", false, "download and use a pinned version of Chromium kept in the config directory instead of the system browser")
+	keepFailed         = flag.Bool("keep-failed", false, "keep the error, a screenshot and any file from failed downloads in the failed directory in the config directory")
+	keepFailedSize     = flag.Int64("keep-failed-size", 100, "max MiB of failed downloads to keep with -keep-failed, removing the oldest first")
+	gzipJSON           = flag.Bool("gzip", true, "compress JSON and metrics responses, eg album listings, if the client accepts gzip")
+	tlsCert            = flag.String("tls-cert", "", "TLS certificate file to serve HTTPS and HTTP/2 (use with -tls-key)")
+	tlsKey             = flag.String("tls-key", "", "TLS private key file for -tls-cert")
+	http2              = flag.Bool("http2", true, "offer HTTP/2 to clients when serving HTTPS")
+	staleTempAge       = flag.Duration("stale-temp-age", 24*time.Hour, "at startup remove download directories left by earlier runs which haven't changed for this long (0 to disable)")
+	check              = flag.Bool("check", false, "start the browser, check it is logged in and exit with 0 if so, 3 if not or 2 on other errors")
+	mockKeychain       = flag.Bool("mock-keychain", true, "on macOS encrypt the browser cookies with a fixed key rather than one from the Keychain - use the same setting with -login")
+	refreshSession     = flag.Duration("refresh-session", 0, "load the Google Photos home page this often when idle to keep the login fresh, eg 1h (0 to disable)")
+	maxConnections     = flag.Int("max-connections", 0, "max number of HTTP connections open at once - more wait until one closes (0 for unlimited)")
+	outputDir          = flag.String("output-dir", "", "write photos from POST /batch or -ids straight into this directory instead of sending them")
+	idsFile            = flag.String("ids", "", "file of photo IDs, one per line (- for stdin), to download into -output-dir then exit")
+	maxPerHour         = flag.Int("max-per-hour", 0, "max number of photos to download in an hour - more get a 429 error with Retry-After (0 for unlimited)")
+	extraHeaders       = headerVar("header", "add this KEY:VALUE header to every response, eg \"Access-Control-Allow-Origin: *\" - can be repeated")
+	shutdownTimeout    = flag.Duration("shutdown-timeout", time.Minute, "max time to wait for requests in progress to finish when shutting down")
+	profileMode        = flag.String("profile-mode", "shared", "how the browser uses the profile: shared uses the one in the config directory, copy runs on a throwaway copy of it made at each launch")
+	realIDs            = flag.Bool("real-ids", false, "the IDs asked for are real photo IDs, so go straight to -real-photo-url without a redirect")
+	benchmark          = flag.Int("benchmark", 0, "download -benchmark-id this many times, log the downloads per minute and the time each phase took, then exit")
+	benchmarkID        = flag.String("benchmark-id", photoID, "photo ID to download with -benchmark")
+	fetchFallback      = flag.Bool("fetch-fallback", false, "if the browser won't start a download, fetch the original from the URL the page shows it from using the browser's cookies")
+	directMode         = flag.String("direct", "none", "use the browser only to find the original then fetch it outside the browser (fetch) or redirect the client to it (redirect), or none")
+	maxFileAge         = flag.Duration("max-file-age", 6*time.Hour, "remove files left in the download directory for longer than this, eg by failed requests (0 to disable)")
+	downloadTimeout    = flag.Duration("download-timeout", time.Hour, "max time to wait for the browser to finish a download once it has started (0 for no limit)")
 )
 
 // Global variables
 var (
 	configRoot    string      // top level config dir, typically ~/.config/gphotodl
 	browserConfig string      // work directory for browser instance
+	browserRunDir string      // user data directory the browser runs with - browserConfig or a copy of it
 	browserPath   string      // path to the browser binary
 	downloadDir   string      // temporary directory for downloads
 	browserPrefs  string      // JSON config for the browser
+	windowWidth   int         // browser window width from -window-size
+	windowHeight  int         // browser window height from -window-size
 	version       = "DEV"     // set by goreleaser
 	commit        = "NONE"    // set by goreleaser
 	date          = "UNKNOWN" // set by goreleaser
@@ -66,15 +159,54 @@ func removeDownloadDirectory() {
 	}
 }
 
+// Return the name of the environment variable for the flag name
+func envName(name string) string {
+	return strings.ToUpper(program + "_" + strings.ReplaceAll(name, "-", "_"))
+}
+
+// Set any flags not set on the command line from their environment
+// variables, eg GPHOTOSDL_ADDR for -addr
+func setFlagsFromEnv() (err error) {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	flag.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		value, found := os.LookupEnv(envName(f.Name))
+		if !found {
+			return
+		}
+		err = flag.Set(f.Name, value)
+		if err != nil {
+			err = fmt.Errorf("invalid value %q for environment variable %s: %w", value, envName(f.Name), err)
+		}
+	})
+	return err
+}
+
 // Set up the global variables from the flags
 func config() (err error) {
 	version := fmt.Sprintf("%s version %s, commit %s, built at %s", program, version, commit, date)
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nAny flag can also be set with an environment variable, eg -config-dir with %s.\n", envName("config-dir"))
 		fmt.Fprintf(os.Stderr, "\n%s\n", version)
 	}
 	flag.Parse()
+	err = setFlagsFromEnv()
+	if err != nil {
+		return err
+	}
+	if *configFile != "" {
+		err = setFlagsFromConfigFile(*configFile)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Set up the logger
 	level := slog.LevelInfo
@@ -82,36 +214,147 @@ func config() (err error) {
 		level = slog.LevelDebug
 	}
 	if *useJSON {
-		logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+		logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
 		slog.SetDefault(logger)
-	} else {
-		slog.SetLogLoggerLevel(level) // set log level of Default Handler
 	}
+	setLogLevel(level)
 	slog.Debug(version)
 
-	configRoot, err = os.UserConfigDir()
+	if *windowSize != "" {
+		_, err = fmt.Sscanf(*windowSize, "%dx%d", &windowWidth, &windowHeight)
+		if err != nil || windowWidth <= 0 || windowHeight <= 0 {
+			return fmt.Errorf("invalid -window-size %q: use WIDTHxHEIGHT, eg 1920x1080", *windowSize)
+		}
+	}
+
+	switch *waitMode {
+	case "load", "stable", "idle":
+	default:
+		return fmt.Errorf("unknown -wait strategy %q: use load, stable or idle", *waitMode)
+	}
+
+	switch *resetMode {
+	case "none", "blank", "page":
+	default:
+		return fmt.Errorf("unknown -reset mode %q: use none, blank or page", *resetMode)
+	}
+
+	switch *headlessMode {
+	case "new", "old":
+	default:
+		return fmt.Errorf("unknown -headless mode %q: use new or old", *headlessMode)
+	}
+
+	if *authPoll <= 0 {
+		return fmt.Errorf("-auth-poll must be positive, not %v", *authPoll)
+	}
+
+	switch *parkMode {
+	case "none", "home", "blank":
+	default:
+		return fmt.Errorf("unknown -park mode %q: use none, home or blank", *parkMode)
+	}
+
+	if *maxFileAge > 0 && *maxFileAge < *writeTimeout {
+		slog.Warn("-max-file-age is less than -write-timeout so files may be removed while they are being sent", "max_file_age", *maxFileAge, "write_timeout", *writeTimeout)
+	}
+
+	switch *directMode {
+	case "none", "fetch", "redirect":
+	default:
+		return fmt.Errorf("unknown -direct mode %q: use none, fetch or redirect", *directMode)
+	}
+
+	err = checkMotionPart(*motionPart)
 	if err != nil {
-		return fmt.Errorf("didn't find config directory: %w", err)
+		return fmt.Errorf("invalid -motion: %w", err)
+	}
+
+	if *noDisk && *once != "" {
+		return errors.New("-no-disk can't be used with -once")
+	}
+	if *noDisk && *benchmark > 0 {
+		return errors.New("-no-disk can't be used with -benchmark")
+	}
+	if *noDisk && *directMode == "fetch" {
+		return errors.New("-no-disk can't be used with -direct fetch")
+	}
+
+	switch *profileMode {
+	case "shared", "copy":
+	default:
+		return fmt.Errorf("unknown -profile-mode %q: use shared or copy", *profileMode)
+	}
+	if *profileMode == "copy" && (*login || *remoteLogin || *importProfilePath != "") {
+		return errors.New("-profile-mode copy can't be used with -login, -remote-login or -import-profile")
+	}
+
+	if *idsFile != "" && *outputDir == "" {
+		return errors.New("-ids needs -output-dir")
+	}
+	if *outputDir != "" {
+		if *noDisk {
+			return errors.New("-output-dir can't be used with -no-disk")
+		}
+		err = os.MkdirAll(*outputDir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to make -output-dir: %w", err)
+		}
+	}
+
+	if *fileModeFlag != "" {
+		fileMode, err = parseFileMode(*fileModeFlag)
+		if err != nil {
+			return err
+		}
+	}
+
+	configRoot = *configDir
+	if configRoot == "" {
+		configRoot, err = os.UserConfigDir()
+		if err != nil {
+			return fmt.Errorf("didn't find config directory - set one with -config-dir: %w", err)
+		}
+		configRoot = filepath.Join(configRoot, program)
 	}
-	configRoot = filepath.Join(configRoot, program)
 	browserConfig = filepath.Join(configRoot, "browser")
 	err = os.MkdirAll(browserConfig, 0700)
 	if err != nil {
 		return fmt.Errorf("config directory creation: %w", err)
 	}
 	slog.Debug("Configured config", "config_root", configRoot, "browser_config", browserConfig)
+	browserRunDir = browserConfig
+	if *profileMode == "shared" {
+		err = clearStaleLock()
+		if err != nil {
+			return err
+		}
+	}
 
+	removeStaleDownloadDirs()
 	downloadDir, err = os.MkdirTemp("", program)
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = setDownloadDirMode()
+	if err != nil {
+		return err
+	}
+	recordDownloadDirOwner()
 	slog.Debug("Created download directory", "download_directory", downloadDir)
 
 	// Find the browser
-	var ok bool
-	browserPath, ok = launcher.LookPath()
-	if !ok {
-		return errors.New("browser not found")
+	if *managedBrowser {
+		browserPath, err = managedBrowserPath()
+		if err != nil {
+			return err
+		}
+	} else {
+		var ok bool
+		browserPath, ok = launcher.LookPath()
+		if !ok {
+			return errors.New("browser not found - install Chrome or Chromium or use -managed-browser")
+		}
 	}
 	slog.Debug("Found browser", "browser_path", browserPath)
 
@@ -121,6 +364,11 @@ func config() (err error) {
 			"default_directory": "/tmp/gphotos", // FIXME
 		},
 	}
+	if *lang != "" {
+		pref["intl"] = map[string]any{
+			"accept_languages": *lang,
+		}
+	}
 	prefJSON, err := json.Marshal(pref)
 	if err != nil {
 		return fmt.Errorf("failed to make preferences: %w", err)
@@ -128,9 +376,52 @@ func config() (err error) {
 	browserPrefs = string(prefJSON)
 	slog.Debug("made browser preferences", "prefs", browserPrefs)
 
+	logConfig()
 	return nil
 }
 
+// Flags whose values shouldn't be logged
+var secretFlags = map[string]bool{
+	"api-key": true,
+}
+
+// Log a summary of the effective configuration
+func logConfig() {
+	args := []any{
+		"config_root", configRoot,
+		"download_directory", downloadDir,
+		"browser_path", browserPath,
+		"headless", !*show,
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		args = append(args, f.Name, redactedValue(f))
+	})
+	slog.Info("Configuration", args...)
+}
+
+// Returns the value of f with any secrets in it replaced by REDACTED
+//
+// As well as the secretFlags this hides the values of -header, which
+// may hold tokens, and the user name and password of any URL.
+func redactedValue(f *flag.Flag) string {
+	value := f.Value.String()
+	if value == "" {
+		return value
+	}
+	if secretFlags[f.Name] {
+		return "REDACTED"
+	}
+	if h, ok := f.Value.(*headerFlag); ok {
+		return h.redacted()
+	}
+	u, err := url.Parse(value)
+	if err == nil && u.User != nil {
+		u.User = url.User("REDACTED")
+		return u.String()
+	}
+	return value
+}
+
 // logger makes an io.Writer from slog.Debug
 type logger struct{}
 
@@ -138,6 +429,7 @@ type logger struct{}
 func (logger) Write(p []byte) (n int, err error) {
 	s := string(p)
 	s = strings.TrimSpace(s)
+	checkDecryptFailed(s)
 	slog.Debug(s)
 	return len(p), nil
 }
@@ -151,98 +443,426 @@ func (logger) Println(vs ...any) {
 
 // Gphotos is a single page browser for Google Photos
 type Gphotos struct {
-	browser *rod.Browser
-	page    *rod.Page
-	mu      sync.Mutex // only one download at once is allowed
+	browser         *rod.Browser
+	page            *rod.Page
+	server          *http.Server
+	cache           *fileCache
+	throttle        throttle
+	limiter         rateLimiter
+	errors          recentErrors
+	stats           downloadStats
+	queueWait       waitHistogram // time downloads waited for the worker and lock
+	lockWait        waitHistogram // time downloads blocked acquiring the lock
+	flights         downloadFlights
+	jobs            chan *downloadJob // downloads for the download worker
+	crashes         crashTracker
+	launcher        *launcher.Launcher
+	mu              sync.Mutex                         // only one download at once is allowed
+	cancelJob       atomic.Pointer[context.CancelFunc] // cancels the page context of the running download
+	queued          atomic.Int64                       // number of downloads waiting for or holding the lock
+	starting        atomic.Bool                        // set until the browser has started for the first time
+	restarting      atomic.Bool                        // set while the browser is being restarted
+	degraded        atomic.Bool                        // set if the browser failed to restart too many times
+	closing         atomic.Bool                        // set when the browser is being shut down
+	shutdown        chan struct{}                      // closed to ask main to shut down
+	shutdownOnce    sync.Once
+	startTime       atomic.Int64 // typical time in ns the browser takes to start
+	restartDue      atomic.Int64 // when the restart in progress should finish in unix ns
+	parkTimer       *time.Timer  // parks the page when idle - protected by mu
+	startedAt       time.Time    // when the browser was last started - protected by mu
+	restartFailures int          // number of failed browser restarts in a row - protected by mu
 }
 
-// New creates a new browser on the gphotos main page to check we are logged in
-func New() (*Gphotos, error) {
-	g := &Gphotos{}
-	err := g.startBrowser()
-	if err != nil {
-		return nil, err
+// New creates a new Gphotos
+//
+// Call Start to start the browser. Until then downloads return
+// errBrowserStarting.
+func New() *Gphotos {
+	g := &Gphotos{
+		cache:    newFileCache(),
+		jobs:     make(chan *downloadJob),
+		shutdown: make(chan struct{}),
 	}
-	err = g.startServer()
+	g.starting.Store(true)
+	go g.downloadWorker()
+	return g
+}
+
+// Start the browser and wait for it to be authenticated
+func (g *Gphotos) Start() error {
+	err := g.timeStart()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	g.starting.Store(false)
+	g.stats.start()
+	return nil
+}
+
+// Set l to run headless in the mode set by -headless
+//
+// "new" is the full browser running without a window, which downloads
+// the same way as a normal browser. "old" is the original lightweight
+// headless mode which uses less memory but isn't in Chrome 132 and
+// later.
+func setHeadless(l *launcher.Launcher) *launcher.Launcher {
+	if *headlessMode == "old" {
+		return l.Set(flags.Headless, "old")
 	}
-	return g, nil
+	return l.HeadlessNew(true)
 }
 
 // start the browser off and check it is authenticated
 func (g *Gphotos) startBrowser() error {
+	if *profileMode == "copy" {
+		dir, err := copyBrowserProfile()
+		if err != nil {
+			return err
+		}
+		browserRunDir = dir
+	}
+
 	// We use the default profile in our new data directory
 	l := launcher.New().
 		Bin(browserPath).
-		Headless(!*show).
-		UserDataDir(browserConfig).
+		UserDataDir(browserRunDir).
 		Preferences(browserPrefs).
 		Set("disable-gpu").
 		Set("disable-audio-output").
 		Logger(logger{})
+	l = setKeychain(l)
+	if !*show {
+		l = setHeadless(l)
+	}
+	if *userAgent != "" {
+		l = l.Set("user-agent", *userAgent)
+	}
+	if *lang != "" {
+		l = l.Set("lang", *lang)
+	}
+	if *windowSize != "" {
+		l = l.Set("window-size", fmt.Sprintf("%d,%d", windowWidth, windowHeight))
+	}
 
 	url, err := l.Launch()
 	if err != nil {
 		return fmt.Errorf("browser launch: %w", err)
 	}
+	g.launcher = l
+	g.startedAt = time.Now()
 
 	g.browser = rod.New().
 		ControlURL(url).
 		NoDefaultDevice().
-		Trace(true).
-		SlowMotion(100 * time.Millisecond).
+		Trace(*debug).
+		SlowMotion(*slowMotion).
 		Logger(logger{})
 
 	err = g.browser.Connect()
 	if err != nil {
 		return fmt.Errorf("failed to connect to browser: %w", err)
 	}
+	g.checkBrowserVersion()
 
-	g.page, err = g.browser.Page(proto.TargetCreateTarget{URL: gphotosURL})
+	g.page, err = g.browser.Page(proto.TargetCreateTarget{URL: accountURL(gphotosURL)})
 	if err != nil {
 		return fmt.Errorf("couldn't open gphotos URL: %w", err)
 	}
 
+	// Make the UI language predictable for the checks which read the page
+	if *lang != "" {
+		_, err = g.page.SetExtraHeaders([]string{"Accept-Language", *lang})
+		if err != nil {
+			return fmt.Errorf("failed to set Accept-Language: %w", err)
+		}
+	}
+
 	eventCallback := func(e *proto.PageLifecycleEvent) {
 		slog.Debug("Event", "Name", e.Name, "Dump", e)
 	}
 	g.page.EachEvent(eventCallback)
 
-	err = g.page.WaitLoad()
+	err = g.waitPage()
 	if err != nil {
 		return fmt.Errorf("gphotos page load: %w", err)
 	}
 
-	authenticated := false
-	for try := 0; try < 60; try++ {
-		time.Sleep(1 * time.Second)
-		info := g.page.MustInfo()
+	err = g.waitAuthenticated()
+	if err != nil {
+		return err
+	}
+	go g.watchBrowser(g.browser)
+	return nil
+}
+
+// Poll the page URL every -auth-poll until it shows the browser is
+// logged in, giving up after -auth-timeout
+func (g *Gphotos) waitAuthenticated() error {
+	ctx, cancel := context.WithTimeout(context.Background(), *authTimeout)
+	defer cancel()
+	ticker := time.NewTicker(*authPoll)
+	defer ticker.Stop()
+	for {
+		info, err := g.page.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read page info: %w", err)
+		}
 		slog.Debug("URL", "url", info.URL)
-		// When not authenticated Google redirects away from the Photos URL
-		if info.URL == gphotosURL {
-			authenticated = true
+		err = checkAccountChooser(info.URL)
+		if err != nil {
+			return err
+		}
+		if isAuthenticatedURL(info.URL) {
 			slog.Debug("Authenticated")
-			break
+			return nil
 		}
 		slog.Info("Please log in, or re-run with -login flag")
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("browser is not logged in after %v - rerun with the -login flag or increase -auth-timeout: %w", *authTimeout, errKindNotAuth)
+		}
+	}
+}
+
+// Wait for the page to load using the strategy set with -wait
+func (g *Gphotos) waitPage() error {
+	return waitLoaded(g.page)
+}
+
+// Wait for page to load using the strategy set with -wait
+func waitLoaded(page *rod.Page) error {
+	page = page.Timeout(*waitTimeout)
+	switch *waitMode {
+	case "stable":
+		return page.WaitStable(time.Second)
+	case "idle":
+		err := page.WaitLoad()
+		if err != nil {
+			return err
+		}
+		return page.WaitIdle(*waitTimeout)
+	}
+	return page.WaitLoad()
+}
+
+// Reset the page before opening a new photo using the mode set with
+// -reset so no state from the last photo is left behind
+//
+// Google Photos is a single page app so without this navigating to a
+// new photo may leave the old one in the DOM.
+func (g *Gphotos) resetPage() error {
+	switch *resetMode {
+	case "blank":
+		err := g.page.Navigate("about:blank")
+		if err != nil {
+			return fmt.Errorf("failed to navigate to blank page: %w", err)
+		}
+		return g.page.Timeout(*waitTimeout).WaitLoad()
+	case "page":
+		page, err := g.browser.Page(proto.TargetCreateTarget{URL: "about:blank"})
+		if err != nil {
+			return fmt.Errorf("failed to open new page: %w", err)
+		}
+		if *lang != "" {
+			_, err = page.SetExtraHeaders([]string{"Accept-Language", *lang})
+			if err != nil {
+				_ = page.Close()
+				return fmt.Errorf("failed to set Accept-Language: %w", err)
+			}
+		}
+		old := g.page
+		g.page = page
+		err = old.Close()
+		if err != nil {
+			slog.Debug("Failed to close old page", "err", err)
+		}
+	}
+	return nil
+}
+
+// errWrongPhoto is returned if the browser isn't showing the photo
+// which was requested
+var errWrongPhoto = errors.New("browser is showing the wrong photo")
+
+// Wait for the page to show photoID or realID so Shift-D downloads the
+// right photo
+func (g *Gphotos) waitActivePhoto(photoID, realID string) error {
+	deadline := time.Now().Add(*waitTimeout)
+	for {
+		err := g.checkActivePhoto(photoID, realID)
+		if !errors.Is(err, errWrongPhoto) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %w", err, errKindTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Check the page is showing photoID or realID, returning an error
+// wrapping errWrongPhoto if not
+//
+// This is checked again just before pressing Shift-D, as downloading
+// the wrong photo under the requested ID would silently corrupt the
+// copy.
+func (g *Gphotos) checkActivePhoto(photoID, realID string) error {
+	photoID = pagePhotoID(photoID)
+	info, err := g.page.Info()
+	if err != nil {
+		return fmt.Errorf("failed to read page info: %w", err)
 	}
-	if !authenticated {
-		return errors.New("browser is not log logged in - rerun with the -login flag")
+	if u, err := url.Parse(info.URL); err == nil {
+		for _, part := range strings.Split(u.Path, "/") {
+			if part == photoID || (realID != "" && part == realID) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: want %q but page is %q", errWrongPhoto, photoID, info.URL)
+}
+
+// Wait for the element in -wait-element to appear which shows the
+// photo is ready for Shift-D to work
+func (g *Gphotos) waitDownloadable() error {
+	if *waitElement == "" {
+		return nil
+	}
+	_, err := g.page.Timeout(*waitTimeout).Element(*waitElement)
+	if err != nil {
+		return fmt.Errorf("waiting for %q: %w", *waitElement, err)
+	}
+	slog.Debug("Download element found", "selector", *waitElement)
+	return nil
+}
+
+// Returns true if the browser landed on url when visiting gphotosURL
+// because it is logged in
+//
+// The query and fragment are ignored as Google sometimes adds them, eg
+// https://photos.google.com/?pli=1
+func isAuthenticatedURL(rawURL string) bool {
+	// When not authenticated Google redirects away from the Photos URL
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	want, err := url.Parse(accountURL(gphotosURL))
+	if err != nil {
+		return false
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	return u.Scheme == want.Scheme && u.Host == want.Host && path == strings.TrimSuffix(want.Path, "/")
+}
+
+// Rewrite the Google Photos URL u to use the account set with
+// -account-index if the browser is signed in to more than one
+func accountURL(u string) string {
+	if *accountIndex <= 0 {
+		return u
+	}
+	return gphotosURL + fmt.Sprintf("u/%d/", *accountIndex) + strings.TrimPrefix(u, gphotosURL)
+}
+
+// Returns an error if the browser is showing the Google account chooser
+//
+// This happens when more than one account is signed in to the browser.
+func checkAccountChooser(u string) error {
+	lower := strings.ToLower(u)
+	if strings.HasPrefix(lower, "https://accounts.google.com/") && strings.Contains(lower, "accountchooser") {
+		return fmt.Errorf("google is showing the account chooser - set -account-index to pick an account or re-run with -login and sign in to one account only: %w", errKindNotAuth)
+	}
+	return nil
+}
+
+// Returns an error if Google has interrupted the session with a page
+// asking the user to do something, eg "Verify it's you" or a consent
+// screen, rather than showing the photo
+//
+// These are on accounts.google.com or consent.google.com and need the
+// user to complete them in a browser they can see.
+func checkVerification(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil
+	}
+	switch strings.ToLower(parsed.Host) {
+	case "accounts.google.com", "consent.google.com":
+		return fmt.Errorf("google wants you to verify your account or give consent (%s) - re-run with -login and complete it in the browser: %w", parsed.Host+parsed.Path, errKindNotAuth)
 	}
 	return nil
 }
 
 // start the web server off
 func (g *Gphotos) startServer() error {
-	http.HandleFunc("GET /", g.getRoot)
-	http.HandleFunc("GET /id/{photoID}", g.getID)
+	// Use our own mux so nothing registered on http.DefaultServeMux
+	// (like the pprof handlers) is served
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /", g.getRoot)
+	mux.HandleFunc("GET /id/{photoID}", g.getID)
+	mux.HandleFunc("GET /id/{photoID}/{$}", g.getID)
+	mux.HandleFunc("GET /id/{photoID}/{part}", g.getID)
+	mux.HandleFunc("GET /url", g.getURL)
+	mux.HandleFunc("GET /share/{shareID}/photo/{photoID}", g.getShared)
+	mux.HandleFunc("GET /share/{shareID}/photo/{photoID}/{$}", g.getShared)
+	mux.HandleFunc("GET /share/{shareID}/photo/{photoID}/{part}", g.getShared)
+	mux.HandleFunc("GET /album/{albumID}", gzipHandler(g.getAlbum))
+	mux.HandleFunc("GET /metadata/{photoID}", gzipHandler(g.getMetadata))
+	mux.HandleFunc("GET /metadata/{photoID}/{$}", gzipHandler(g.getMetadata))
+	mux.HandleFunc("GET /auth", gzipHandler(requireAPIKey(g.getAuth)))
+	mux.HandleFunc("GET /status", gzipHandler(requireAPIKey(g.getStatus)))
+	mux.HandleFunc("GET /quota", gzipHandler(requireAPIKey(g.getQuota)))
+	mux.HandleFunc("GET /error-kinds", gzipHandler(getErrorKinds))
+	mux.HandleFunc("GET /errors", gzipHandler(requireAPIKey(g.getErrors)))
+	mux.HandleFunc("GET /stats", gzipHandler(requireAPIKey(g.getStats)))
+	mux.HandleFunc("GET /metrics", gzipHandler(requireAPIKey(g.getMetrics)))
+	mux.HandleFunc("GET /loglevel", gzipHandler(requireAPIKey(getLogLevel)))
+	mux.HandleFunc("POST /loglevel", requireAPIKey(postLogLevel))
+	mux.HandleFunc("POST /batch", gzipHandler(g.postBatch))
+	mux.HandleFunc("POST /admin/reset-page", requireAPIKey(g.postResetPage))
+	mux.HandleFunc("POST /admin/shutdown", requireAPIKey(g.postShutdown))
+	var handler http.Handler = mux
+	if len(extraHeaders.header) > 0 {
+		handler = headersHandler(handler)
+	}
+	g.server = &http.Server{
+		Addr:              *addr,
+		Handler:           http.MaxBytesHandler(handler, maxRequestBody),
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+
+	useTLS, err := g.configureTLS()
+	if err != nil {
+		return err
+	}
+
+	// Bind the port now so a conflict is reported before we say we
+	// are ready
+	g.server.SetKeepAlivesEnabled(*keepAlive)
+	listenConfig := net.ListenConfig{KeepAlive: *tcpKeepAlive}
+	listener, err := listenConfig.Listen(context.Background(), "tcp", *addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q - is another gphotosdl running?: %w", *addr, err)
+	}
+	if *maxConnections > 0 {
+		listener = newLimitListener(listener, *maxConnections)
+	}
+	slog.Info("Web server listening", "addr", listener.Addr().String(), "tls", useTLS, "http2", useTLS && *http2)
 	go func() {
-		err := http.ListenAndServe(*addr, nil)
+		var err error
+		if useTLS {
+			err = g.server.ServeTLS(listener, "", "")
+		} else {
+			err = g.server.Serve(listener)
+		}
 		if errors.Is(err, http.ErrServerClosed) {
 			slog.Debug("web server closed")
 		} else if err != nil {
-			slog.Error("Error starting web server", "err", err)
+			slog.Error("Error running web server", "err", err)
 			os.Exit(1)
 		}
 	}()
@@ -273,23 +893,98 @@ func (g *Gphotos) getRoot(w http.ResponseWriter, r *http.Request) {
 
 // Serve a photo ID
 func (g *Gphotos) getID(w http.ResponseWriter, r *http.Request) {
-	photoID := r.PathValue("photoID")
-	slog.Info("got photo request", "id", photoID)
-	path, err := g.Download(photoID)
-	if err != nil {
-		slog.Error("Download image failed", "id", photoID, "err", err)
-		var h httpError
-		if errors.As(err, &h) {
-			w.WriteHeader(int(h))
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+	g.servePhoto(w, r, normalizeID(r.PathValue("photoID")), normalizeID(r.PathValue("part")))
+}
+
+// Serve the part of the photo with the ID given
+func (g *Gphotos) servePhoto(w http.ResponseWriter, r *http.Request, photoID, part string) {
+	// The files are deleted once served so mustn't be cached
+	if *cacheControl != "" {
+		w.Header().Set("Cache-Control", *cacheControl)
+	}
+	if part == "" {
+		part = *motionPart
+	}
+	requestID := newRequestID()
+	slog.Info("got photo request", "id", photoID, "part", part, "request_id", requestID)
+	err := checkPhotoID(photoID)
+	if err == nil {
+		err = checkMotionPart(part)
+	}
+	if err != nil {
+		writeError(w, photoID, err)
+		return
+	}
+	cacheKey := photoID
+	if part != motionOriginal {
+		cacheKey += "/" + part
+	}
+	if *noDisk {
+		if part != motionOriginal {
+			writeError(w, photoID, errNoDiskUnsupported)
+			return
 		}
+		g.getIDNoDisk(w, photoID, requestID)
+		return
+	}
+	if *directMode == "redirect" && part == motionOriginal {
+		g.redirectToOriginal(w, r, photoID, requestID)
 		return
 	}
-	slog.Info("Downloaded photo", "id", photoID, "path", path)
+	d, cached := g.cache.take(cacheKey)
+	shared := false
+	if !cached && *streamDownloads && part == motionOriginal {
+		g.getIDStream(w, photoID, requestID)
+		return
+	}
+	if cached {
+		slog.Info("Serving photo from retry cache", "id", photoID, "path", d.Path)
+	} else {
+		start := time.Now()
+		d, shared, err = g.flights.do(cacheKey, func() (*Downloaded, error) {
+			d, err := g.Download(photoID)
+			if err == nil {
+				err = extractMotion(d, part)
+				if err != nil {
+					_ = os.Remove(d.Path)
+				}
+			}
+			return d, err
+		})
+		w.Header().Set("X-Queue-Wait-Ms", strconv.FormatInt(d.QueueWait.Milliseconds(), 10))
+		if err != nil {
+			slog.Error("Download image failed", "id", photoID, "err", err)
+			logTimings(requestID, photoID, d, "shared", shared)
+			writeError(w, photoID, err)
+			return
+		}
+		slog.Info("Downloaded photo", "id", photoID, "path", d.Path, "size", d.Size, "mime_type", mime.TypeByExtension(filepath.Ext(d.Path)), "duration", time.Since(start), "shared", shared)
+	}
+	if d.RealID != "" {
+		w.Header().Set("X-Real-Photo-ID", d.RealID)
+	}
+	path := d.Path
+	if d.Name != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": d.Name}))
+	}
 
-	// Remove the file after it has been served
+	// Remove the file after it has been served to every request
+	// sharing it, unless sending it failed in which case keep it for a
+	// while for the client to retry
+	rw := &responseWriter{ResponseWriter: w}
 	defer func() {
+		failed := rw.err != nil
+		if !cached {
+			var last bool
+			last, failed = g.flights.release(cacheKey, failed)
+			if !last {
+				return
+			}
+		}
+		if failed && *retryCacheTTL > 0 {
+			g.cache.put(cacheKey, d, *retryCacheTTL)
+			return
+		}
 		err := os.Remove(path)
 		if err == nil {
 			slog.Debug("Removed downloaded photo", "id", photoID, "path", path)
@@ -298,81 +993,538 @@ func (g *Gphotos) getID(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	http.ServeFile(w, r, path)
+	serveStart := time.Now()
+	http.ServeFile(rw, r, path)
+	if rw.err != nil {
+		slog.Error("Failed to send photo to client", "id", photoID, "sent", rw.n, "err", rw.err)
+	}
+	if !cached {
+		logTimings(requestID, photoID, d, slog.Duration("serve", time.Since(serveStart)), "shared", shared)
+	}
+}
+
+// responseWriter records how much was written to the client and the
+// first error doing so
+type responseWriter struct {
+	http.ResponseWriter
+	n           int64
+	err         error
+	wroteHeader bool
+}
+
+// WriteHeader sends the status code to the client
+func (w *responseWriter) WriteHeader(statusCode int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes p to the client recording any error
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.record(int64(n), err)
+	return n, err
+}
+
+// ReadFrom passes on to the underlying ReadFrom if possible so
+// http.ServeFile can still use sendfile
+func (w *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.wroteHeader = true
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+	}
+	w.record(n, err)
+	return n, err
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Record n bytes written and err if it is the first error
+func (w *responseWriter) record(n int64, err error) {
+	w.n += n
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// errGoogleErrorPage is returned when Google shows its "Something went
+// wrong" page instead of the photo. Retrying after a short delay
+// usually works.
+var errGoogleErrorPage = errors.New("google photos showed its error page")
+
+// Text which appears on Google's generic error page
+var errorPageText = []string{
+	"Something went wrong",
+}
+
+// Check to see if Google is showing its error page rather than the photo
+func (g *Gphotos) checkErrorPage() error {
+	return checkGoogleErrorPage(g.page)
 }
 
-// httpError wraps an HTTP status code
-type httpError int
+// Returns errGoogleErrorPage if page is showing one of Google's error
+// pages
+func checkGoogleErrorPage(page *rod.Page) error {
+	info, err := page.Info()
+	if err != nil {
+		return fmt.Errorf("failed to read page info: %w", err)
+	}
+	res, err := page.Eval(`() => document.body ? document.body.innerText : ""`)
+	if err != nil {
+		return fmt.Errorf("failed to read page text: %w", err)
+	}
+	text := res.Value.Str()
+	for _, phrase := range errorPageText {
+		if strings.Contains(info.Title, phrase) || strings.Contains(text, phrase) {
+			slog.Debug("Found Google error page", "title", info.Title, "url", info.URL)
+			return fmt.Errorf("%w %q: %w", errGoogleErrorPage, info.Title, errKindErrorPage)
+		}
+	}
+	return nil
+}
+
+// errDiskFreeUnsupported is returned by diskFree on OSes where it isn't implemented
+var errDiskFreeUnsupported = errors.New("reading free disk space is not supported on this OS")
+
+// Check there is at least -min-free-space free for the download directory
+func checkFreeSpace() error {
+	if *minFreeSpace <= 0 {
+		return nil
+	}
+	free, err := diskFree(downloadDir)
+	if errors.Is(err, errDiskFreeUnsupported) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read free disk space: %w", err)
+	}
+	if free < *minFreeSpace<<20 {
+		slog.Error("Not enough free disk space for downloads", "download_directory", downloadDir, "free_mib", free>>20, "min_free_space_mib", *minFreeSpace)
+		return fmt.Errorf("only %d MiB free in download directory: %w", free>>20, errKindDiskFull)
+	}
+	return nil
+}
 
-func (h httpError) Error() string {
-	return fmt.Sprintf("HTTP Error %d", h)
+// Log a warning periodically if the disk is getting full
+func monitorFreeSpace() {
+	for range time.Tick(freeSpaceCheckInterval) {
+		_ = checkFreeSpace()
+	}
+}
+
+// Check size is within -max-file-size
+func checkFileSize(size int64) error {
+	if *maxFileSize <= 0 || size <= *maxFileSize<<20 {
+		return nil
+	}
+	return fmt.Errorf("file is %d MiB which is bigger than -max-file-size %d MiB: %w", size>>20, *maxFileSize, errKindTooLarge)
+}
+
+// errQueueFull is returned when there are more than -max-queue downloads waiting
+var errQueueFull = fmt.Errorf("too many downloads queued: %w", errKindQueueFull)
+
+// Downloaded describes the result of a Download
+type Downloaded struct {
+	Path      string        // path to the photo which should be deleted after use
+	Name      string        // original file name of the photo if known
+	RealID    string        // the real photo ID if known
+	Size      int64         // size of the photo in bytes
+	QueueWait time.Duration // time spent waiting for the download lock
+	timings   phaseTimings  // how long each phase of the download took
 }
 
 // Download a photo with the ID given
 //
-// Returns the path to the photo which should be deleted after use
-func (g *Gphotos) Download(photoID string) (string, error) {
-	// Can only download one picture at once
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	url := gphotoURL + photoID
+// The Downloaded returned is never nil so the stats in it can be read
+// even if there was an error.
+func (g *Gphotos) Download(photoID string) (*Downloaded, error) {
+	d := &Downloaded{}
+	if *noDisk {
+		return d, errNoDiskUnsupported
+	}
+	if *directMode != "none" {
+		return d, g.downloadDirect(photoID, d)
+	}
+	err := g.runDownload(photoID, d, func() error {
+		g.throttle.wait()
+		d.timings.mark("throttle")
+		err := g.download(photoID, d)
+		g.finishDownload(photoID, d, err)
+		return err
+	})
+	return d, err
+}
+
+// Record the result of downloading photoID
+func (g *Gphotos) finishDownload(photoID string, d *Downloaded, err error) {
+	g.throttle.record(err)
+	g.errors.add(photoID, err)
+	g.stats.record(d, err)
+	if err != nil && *screenshotOnError {
+		g.errorScreenshot(photoID)
+	}
+	if err != nil && *keepFailed {
+		g.keepFailedDownload(photoID, d, err)
+	}
+}
+
+// Recreate the download directory with the lock held if something, eg
+// a tmp cleaner, has deleted it
+func (g *Gphotos) ensureDownloadDir() error {
+	_, err := os.Stat(downloadDir)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	slog.Warn("Download directory has been deleted - recreating it", "download_directory", downloadDir)
+	err = os.MkdirAll(downloadDir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to recreate download directory: %w", err)
+	}
+	err = setDownloadDirMode()
+	if err != nil {
+		return err
+	}
+	recordDownloadDirOwner()
+	err = proto.BrowserSetDownloadBehavior{
+		Behavior:     proto.BrowserSetDownloadBehaviorBehaviorAllowAndName,
+		DownloadPath: downloadDir,
+	}.Call(g.browser)
+	if err != nil {
+		return fmt.Errorf("failed to set download directory: %w", err)
+	}
+	return nil
+}
+
+// Navigate to the photo with the ID given and wait for it to be ready
+// to download with the lock held
+//
+// The ID can either be the one for -photo-url or the real ID for
+// -real-photo-url which it redirects to. The real ID is stored in
+// d.RealID.
+//
+// The ID is tried with -photo-url first, unless -real-ids is set in
+// which case -real-photo-url is tried first to save the redirect.
+func (g *Gphotos) preparePhoto(photoID string, d *Downloaded) error {
+	var (
+		netResponse *proto.NetworkResponseReceived
+		err         error
+	)
+	first, second := *photoURL, *realPhotoURL
+	if *realIDs {
+		first, second = second, first
+	}
+	if isSharedPhotoID(photoID) {
+		netResponse, err = g.openPhoto(photoID, accountURL(gphotosShareURL)+photoID, &d.timings)
+	} else {
+		netResponse, err = g.openPhoto(photoID, accountURL(first)+photoID, &d.timings)
+	}
+	if errors.Is(err, upstreamError(http.StatusNotFound)) && !isSharedPhotoID(photoID) && second != first {
+		slog.Debug("Photo not found - trying the other kind of photo ID", "id", photoID, "url", second)
+		netResponse, err = g.openPhoto(photoID, accountURL(second)+photoID, &d.timings)
+	}
+	if err != nil {
+		return err
+	}
+	d.RealID = realPhotoID(netResponse.Response.URL)
+	if d.RealID != "" && d.RealID != photoID {
+		slog.Debug("Found real photo ID", "id", photoID, "real_id", d.RealID)
+	}
+	err = g.waitActivePhoto(photoID, d.RealID)
+	if err != nil {
+		return err
+	}
 
-	var netResponse *proto.NetworkResponseReceived
+	// Wait for the photo to be ready to download
+	err = g.waitDownloadable()
+	if err != nil {
+		return fmt.Errorf("gphoto not ready to download: %w", err)
+	}
+	d.timings.mark("ready")
+
+	// Give the page time to get the download ready on slow machines
+	if *settleDelay > 0 {
+		time.Sleep(*settleDelay)
+		d.timings.mark("settle")
+	}
+	return nil
+}
+
+// Navigate to the photo page url and return the network response for
+// it, recording the time taken in timings
+func (g *Gphotos) openPhoto(photoID, url string, timings *phaseTimings) (*proto.NetworkResponseReceived, error) {
+	var netResponse, lrResponse *proto.NetworkResponseReceived
+
+	err := g.resetPage()
+	if err != nil {
+		return nil, err
+	}
+	timings.mark("reset")
+
+	// Cancel the network listener if we return early, and give up
+	// waiting for it if the photo page never arrives
+	page, cancel := g.page.WithCancel()
+	defer cancel()
+	page = page.Timeout(2 * *waitTimeout)
 
 	// Check the correct network request is received
-	waitNetwork := g.page.EachEvent(func(e *proto.NetworkResponseReceived) bool {
-		slog.Debug("network response", "url", e.Response.URL, "status", e.Response.Status)
-		if strings.HasPrefix(e.Response.URL, gphotoURLReal) {
-			netResponse = e
-			return true
-		} else if strings.HasPrefix(e.Response.URL, gphotoURL) {
+	//
+	// Only the document responses for the photo page are considered so
+	// assets and XHRs loaded by the page can't be mistaken for it. The
+	// lr/photo URL redirects to the real photo URL, so it is only
+	// final if it failed.
+	waitNetwork := page.EachEvent(func(e *proto.NetworkResponseReceived) bool {
+		slog.Debug("network response", "url", e.Response.URL, "status", e.Response.Status, "type", e.Type)
+		if e.Type != proto.NetworkResourceTypeDocument {
+			return false
+		}
+		if strings.HasPrefix(e.Response.URL, accountURL(*realPhotoURL)) || strings.HasPrefix(e.Response.URL, accountURL(gphotosShareURL)) {
 			netResponse = e
 			return true
+		} else if strings.HasPrefix(e.Response.URL, accountURL(*photoURL)) {
+			lrResponse = e
+			if e.Response.Status != http.StatusOK {
+				netResponse = e
+				return true
+			}
 		}
 		return false
 	})
 
 	// Navigate to the photo URL
-	err := g.page.Navigate(url)
+	err = g.page.Navigate(url)
 	if err != nil {
-		return "", fmt.Errorf("failed to navigate to photo %q: %w", photoID, err)
+		return nil, fmt.Errorf("failed to navigate to photo %q: %w", photoID, err)
 	}
-	err = g.page.WaitLoad()
+	timings.mark("navigate")
+	err = g.waitPage()
 	if err != nil {
-		return "", fmt.Errorf("gphoto page load: %w", err)
+		return nil, fmt.Errorf("gphoto page load: %w", err)
+	}
+	timings.mark("load")
+
+	// Google's error page won't produce the network request we wait for
+	pageInfo, err := g.page.Info()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page info: %w", err)
+	}
+	err = checkAccountChooser(pageInfo.URL)
+	if err == nil {
+		err = checkVerification(pageInfo.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = g.checkErrorPage()
+	if err != nil {
+		return nil, err
 	}
 
 	// Wait for the photos network request to happen
 	waitNetwork()
+	timings.mark("network")
+	if netResponse == nil {
+		if lrResponse == nil {
+			return nil, fmt.Errorf("timed out waiting for photo page: %w", errKindTimeout)
+		}
+		// lr/photo didn't redirect so use its response
+		netResponse = lrResponse
+	}
 
-	// Print request headers
+	// Print response headers so rate limit and error headers can be seen
 	if netResponse.Response.Status != 200 {
-		return "", fmt.Errorf("gphoto fetch failed: %w", httpError(netResponse.Response.Status))
+		headers := make([]any, 0, len(netResponse.Response.Headers))
+		for name, value := range netResponse.Response.Headers {
+			headers = append(headers, slog.String(name, value.Str()))
+		}
+		slog.Debug("Photo page failed", "id", photoID, "url", netResponse.Response.URL, "status", netResponse.Response.Status, slog.Group("headers", headers...))
+		return nil, fmt.Errorf("gphoto fetch failed: %w", upstreamError(netResponse.Response.Status))
+	}
+	return netResponse, nil
+}
+
+// Return the real photo ID from a URL under -real-photo-url or "" if
+// it isn't one
+func realPhotoID(u string) string {
+	realID, found := strings.CutPrefix(u, accountURL(*realPhotoURL))
+	if !found {
+		return ""
+	}
+	if i := strings.IndexAny(realID, "?#/"); i >= 0 {
+		realID = realID[:i]
+	}
+	return realID
+}
+
+// Download a photo with the ID given with the lock held
+//
+// This sets the path to the downloaded file and the original file name in d
+func (g *Gphotos) download(photoID string, d *Downloaded) error {
+	err := g.preparePhoto(photoID, d)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the photo didn't change while waiting for it
+	err = g.checkActivePhoto(photoID, d.RealID)
+	if err != nil {
+		return err
 	}
 
 	// Download waiter
-	wait := g.browser.WaitDownload(downloadDir)
+	browser, cancel := g.browser.WithCancel()
+	defer cancel()
+	err = proto.BrowserSetDownloadBehavior{
+		Behavior:         proto.BrowserSetDownloadBehaviorBehaviorAllowAndName,
+		BrowserContextID: browser.BrowserContextID,
+		DownloadPath:     downloadDir,
+	}.Call(browser)
+	if err != nil {
+		return fmt.Errorf("failed to set download behavior: %w", err)
+	}
+	starts := newDownloadStarts()
+	defer starts.removeExtra(browser)
+	finished := make(chan *proto.PageDownloadProgress, 1)
+	wait := browser.EachEvent(starts.began, func(e *proto.PageDownloadProgress) {
+		if e.State == proto.PageDownloadProgressStateInProgress {
+			return
+		}
+		select {
+		case finished <- e:
+		case <-browser.GetContext().Done():
+		}
+	})
+	go func() {
+		wait()
+		close(finished)
+	}()
 
-	// Shift-D to download
-	g.page.KeyActions().Press(input.ShiftLeft).Type('D').MustDo()
+	// Shift-D or the download menu to download
+	info, err := triggerDownload(g, starts.ch)
+	if errors.Is(err, errNoDownload) && *fetchFallback {
+		slog.Warn("Browser didn't start the download - fetching the original directly", "id", photoID)
+		fetchErr := g.fetchOriginal(photoID, d)
+		if fetchErr != nil {
+			return fmt.Errorf("%w and the fetch fallback failed: %v", err, fetchErr)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d.timings.mark("download_start")
 
 	// Wait for download
-	info := wait()
+	err = g.waitDownload(browser, finished, info.GUID)
+	if err != nil {
+		return err
+	}
+	d.timings.mark("download_wait")
 	path := filepath.Join(downloadDir, info.GUID)
 
 	// Check file
 	fi, err := os.Stat(path)
 	if err != nil {
-		return "", fmt.Errorf("download failed: %w", err)
+		return fmt.Errorf("download failed: %w", err)
+	}
+	err = checkFileSize(fi.Size())
+	if err != nil {
+		removeErr := os.Remove(path)
+		if removeErr != nil {
+			slog.Error("Failed to remove oversized download", "path", path, "err", removeErr)
+		}
+		return err
+	}
+	err = setFileMode(path)
+	if err != nil {
+		return err
+	}
+
+	// Give the file the extension of the original so http.ServeFile
+	// sends the correct Content-Type
+	name := info.SuggestedFilename
+	if ext := filepath.Ext(name); ext != "" {
+		newPath := path + strings.ToLower(ext)
+		err = os.Rename(path, newPath)
+		if err != nil {
+			return fmt.Errorf("failed to rename download: %w", err)
+		}
+		path = newPath
+	}
+
+	d.timings.mark("file")
+	slog.Debug("Download successful", "size", fi.Size(), "path", path, "name", name)
+
+	d.Path, d.Name, d.Size = path, name, fi.Size()
+	return nil
+}
+
+// Wait for the download with the GUID given to finish
+//
+// This gives up if the browser stops sending events for it, the page
+// goes away or it takes longer than -download-timeout, so a hung
+// browser can't hold the lock forever.
+func (g *Gphotos) waitDownload(browser *rod.Browser, finished <-chan *proto.PageDownloadProgress, guid string) error {
+	timeout, stop := downloadTimer()
+	defer stop()
+	for {
+		select {
+		case e, ok := <-finished:
+			if !ok {
+				return errors.New("browser stopped sending download events")
+			}
+			if e.GUID != guid {
+				continue
+			}
+			if e.State != proto.PageDownloadProgressStateCompleted {
+				return fmt.Errorf("download was %s by the browser", e.State)
+			}
+			return nil
+		case <-timeout:
+			cancelDownload(browser, guid)
+			return errDownloadTimeout()
+		case <-g.page.GetContext().Done():
+			cancelDownload(browser, guid)
+			return fmt.Errorf("page went away while downloading: %w", g.page.GetContext().Err())
+		}
 	}
+}
 
-	slog.Debug("Download successful", "size", fi.Size(), "path", path)
+// Returns a channel which receives once -download-timeout has passed,
+// or never if it is 0, and a function to stop the timer
+func downloadTimer() (<-chan time.Time, func()) {
+	if *downloadTimeout <= 0 {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(*downloadTimeout)
+	return timer.C, func() { timer.Stop() }
+}
+
+// Returns the error for a download which took longer than
+// -download-timeout
+func errDownloadTimeout() error {
+	return fmt.Errorf("download didn't finish within -download-timeout %v: %w", *downloadTimeout, errKindTimeout)
+}
 
-	return path, nil
+// Ask the browser to cancel the download with the GUID given
+func cancelDownload(browser *rod.Browser, guid string) {
+	err := proto.BrowserCancelDownload{
+		GUID:             guid,
+		BrowserContextID: browser.BrowserContextID,
+	}.Call(browser)
+	if err != nil {
+		slog.Debug("Failed to cancel download", "err", err)
+	}
 }
 
 // Close the browser
 func (g *Gphotos) Close() {
+	g.closing.Store(true)
 	err := g.browser.Close()
 	if err == nil {
 		slog.Debug("Closed browser")
@@ -388,11 +1540,42 @@ func main() {
 		os.Exit(2)
 	}
 	defer removeDownloadDirectory()
+	defer releaseProfile()
+	go monitorFreeSpace()
+	if *maxFileAge > 0 {
+		go cleanDownloadDirEvery()
+	}
+	if *pprofAddr != "" {
+		startPprof()
+	}
+
+	// Copy the login session from an existing browser profile
+	if *importProfilePath != "" {
+		err = importProfile(*importProfilePath)
+		if err != nil {
+			slog.Error("Import profile failed", "err", err)
+			os.Exit(2)
+		}
+		slog.Info("Now restart this program without -import-profile")
+		os.Exit(1)
+	}
+
+	// Log in with a headless browser driven from another machine
+	if *remoteLogin {
+		err = runRemoteLogin()
+		if err != nil {
+			slog.Error("Remote login failed", "err", err)
+			os.Exit(2)
+		}
+		slog.Info("Now restart this program without -remote-login")
+		os.Exit(1)
+	}
 
 	// If login is required, run the browser standalone
 	if *login {
 		slog.Info("Log in to google with the browser that pops up, close it, then re-run this without the -login flag")
-		cmd := exec.Command(browserPath, "--user-data-dir="+browserConfig, gphotosURL)
+		args := append([]string{"--user-data-dir=" + browserConfig}, keychainArgs()...)
+		cmd := exec.Command(browserPath, append(args, gphotosURL)...)
 		err = cmd.Start()
 		if err != nil {
 			slog.Error("Failed to start browser", "err", err)
@@ -408,18 +1591,99 @@ func main() {
 		os.Exit(1)
 	}
 
-	g, err := New()
+	g := New()
+
+	// Check the login and exit without running the web server
+	if *check {
+		code := g.runCheck()
+		removeDownloadDirectory()
+		os.Exit(code)
+	}
+
+	// Download a list of photos into -output-dir without running the
+	// web server
+	if *idsFile != "" {
+		err = g.Start()
+		if err != nil {
+			slog.Error("Failed to make browser", "err", err)
+			os.Exit(2)
+		}
+		err = g.downloadIDsToDir(*idsFile)
+		g.Close()
+		if err != nil {
+			slog.Error("Download to directory failed", "err", err)
+			removeDownloadDirectory()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Measure how fast photos can be downloaded then exit
+	if *benchmark > 0 {
+		err = g.Start()
+		if err != nil {
+			slog.Error("Failed to make browser", "err", err)
+			os.Exit(2)
+		}
+		err = g.runBenchmark(*benchmark, normalizeID(*benchmarkID))
+		g.Close()
+		if err != nil {
+			slog.Error("Benchmark failed", "err", err)
+			removeDownloadDirectory()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Download a single photo without running the web server
+	if *once != "" {
+		err = g.Start()
+		if err != nil {
+			slog.Error("Failed to make browser", "err", err)
+			os.Exit(2)
+		}
+		err = g.downloadOnce(normalizeID(*once), *output)
+		g.Close()
+		if err != nil {
+			slog.Error("Download failed", "id", *once, "err", err)
+			removeDownloadDirectory()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Start the web server first so requests made while the browser
+	// starts get a 503 rather than connection refused
+	err = g.startServer()
+	if err != nil {
+		slog.Error("Failed to start web server", "err", err)
+		os.Exit(2)
+	}
+	err = g.Start()
 	if err != nil {
 		slog.Error("Failed to make browser", "err", err)
 		os.Exit(2)
 	}
 	defer g.Close()
+	g.handleDumpSignals()
+	handleLogLevelSignals()
+	if *maxLifetime > 0 {
+		go g.restartEvery(*maxLifetime)
+	}
+	if *refreshSession > 0 {
+		go g.refreshSessionEvery(*refreshSession)
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, exitSignals...)
 
-	// Wait for CTRL-C or SIGTERM
+	// Wait for CTRL-C, SIGTERM or POST /admin/shutdown
 	slog.Info("Press CTRL-C (or kill) to quit")
-	sig := <-quit
-	slog.Info("Signal received - shutting down", "signal", sig)
+	select {
+	case sig := <-quit:
+		slog.Info("Signal received - shutting down", "signal", sig)
+	case <-g.shutdown:
+		slog.Info("Shutdown requested - shutting down")
+	}
+	g.drainServer()
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/go-rod/rod/lib/launcher"
+)
+
+// Download the pinned Chromium revision into the config directory if
+// it isn't there already and return the path to it
+//
+// This is for -managed-browser so the browser doesn't change under
+// gphotosdl when the system Chrome updates itself.
+func managedBrowserPath() (string, error) {
+	b := launcher.NewBrowser()
+	b.RootDir = filepath.Join(configRoot, "chromium")
+	b.Logger = logger{}
+	err := b.Validate()
+	if err == nil {
+		return b.BinPath(), nil
+	}
+	slog.Info("Downloading browser - this only happens once", "revision", b.Revision, "dir", b.RootDir)
+	path, err := b.Get()
+	if err != nil {
+		return "", fmt.Errorf("failed to download browser revision %d: %w", b.Revision, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-rod/rod/lib/input"
+)
+
+// Selectors for the info panel of the photo viewer
+const (
+	infoPanelKey        = input.Key('i')                       // keyboard shortcut to show the info panel
+	descriptionSelector = `textarea[aria-label="Description"]` // the editable description in the info panel
+	peopleSelector      = `a[href*="/search/"][aria-label]`    // links to the people recognised in the photo
+)
+
+// Metadata is the information about a photo shown in its info panel
+//
+// Fields the photo doesn't have are left out of the JSON.
+type Metadata struct {
+	ID          string   `json:"id"`
+	RealID      string   `json:"real_id,omitempty"`
+	Description string   `json:"description,omitempty"`
+	People      []string `json:"people,omitempty"`
+}
+
+// Serve the metadata of a photo as JSON
+func (g *Gphotos) getMetadata(w http.ResponseWriter, r *http.Request) {
+	photoID := normalizeID(r.PathValue("photoID"))
+	slog.Info("got metadata request", "id", photoID)
+	md, err := g.Metadata(photoID)
+	if err != nil {
+		slog.Error("Metadata failed", "id", photoID, "err", err)
+		writeError(w, photoID, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(md)
+	if err != nil {
+		slog.Debug("Failed to write metadata response", "err", err)
+	}
+}
+
+// Metadata reads the description and the people tagged in the photo
+// with the ID given from its info panel
+//
+// This takes the lock to use the page rather than going through the
+// download worker, so it doesn't use up -max-per-hour or count
+// towards -max-queue. It relies on the page being in English so works
+// best with the default -lang.
+func (g *Gphotos) Metadata(photoID string) (*Metadata, error) {
+	err := checkPhotoID(photoID)
+	if err != nil {
+		return nil, err
+	}
+	err = g.checkBrowser()
+	if err != nil {
+		return nil, err
+	}
+	md, err := g.readMetadata(photoID)
+	// Leave a working page for the next request as the download
+	// worker does
+	if reason, ok := recoveryFor(err); ok {
+		g.recover(reason)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// Open the photo with the ID given and read its metadata with the lock
+// held
+func (g *Gphotos) readMetadata(photoID string) (*Metadata, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	d := &Downloaded{}
+	err := g.preparePhoto(photoID, d)
+	if err != nil {
+		return nil, err
+	}
+	md := &Metadata{ID: photoID, RealID: d.RealID}
+	err = g.readInfoPanel(md)
+	if err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// Open the info panel of the photo showing and read the metadata from
+// it into md
+func (g *Gphotos) readInfoPanel(md *Metadata) error {
+	err := g.focusPage()
+	if err != nil {
+		slog.Debug("Failed to focus page", "err", err)
+	}
+	err = g.page.KeyActions().Type(infoPanelKey).Do()
+	if err != nil {
+		return fmt.Errorf("failed to open info panel: %w", err)
+	}
+	page := g.page.Timeout(*waitTimeout)
+	_, err = page.Element(descriptionSelector)
+	if err != nil {
+		return fmt.Errorf("info panel didn't open: %v: %w", err, errKindBadPage)
+	}
+	res, err := page.Eval(`(descriptionSelector, peopleSelector) => {
+		const description = document.querySelector(descriptionSelector);
+		const people = Array.from(document.querySelectorAll(peopleSelector), e => e.getAttribute("aria-label").trim());
+		return {
+			description: description ? description.value.trim() : "",
+			people: [...new Set(people.filter(name => name !== ""))],
+		};
+	}`, descriptionSelector, peopleSelector)
+	if err != nil {
+		return fmt.Errorf("failed to read info panel: %w", err)
+	}
+	md.Description = res.Value.Get("description").Str()
+	for _, name := range res.Value.Get("people").Arr() {
+		md.People = append(md.People, name.Str())
+	}
+	slog.Debug("Read info panel", "id", md.ID, "description", md.Description, "people", md.People)
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Upper bounds in seconds of the buckets the wait times are counted in
+var waitBuckets = []float64{0.001, 0.01, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// waitHistogram counts how long things waited, in the Prometheus
+// histogram format
+type waitHistogram struct {
+	mu     sync.Mutex
+	counts []uint64 // number of waits in each of waitBuckets, not cumulative
+	sum    float64  // total seconds waited
+	count  uint64   // number of waits
+	max    float64  // longest wait in seconds
+}
+
+// Record a wait of d
+func (h *waitHistogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.counts == nil {
+		h.counts = make([]uint64, len(waitBuckets))
+	}
+	for i, bound := range waitBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+	h.max = max(h.max, seconds)
+}
+
+// Write the histogram called name to w in the Prometheus text format
+func (h *waitHistogram) write(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, bound := range waitBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	_, _ = fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+	writeMetric(w, name+"_max", "gauge", "Longest wait counted in "+name+".", h.max)
+}
+
+// Write a single metric to w in the Prometheus text format
+func writeMetric(w io.Writer, name, kind, help string, value any) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+// Serve the metrics in the Prometheus text format
+//
+// The lock and queue wait times show how much time downloads spend
+// waiting for each other. If the lock wait is small compared to the
+// queue wait then the one browser doing downloads is the bottleneck,
+// otherwise it is something else holding the lock, like browser
+// restarts.
+func (g *Gphotos) getMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	stats := g.stats.snapshot()
+	writeMetric(w, program+"_downloads_total", "counter", "Number of files downloaded.", stats.Files)
+	writeMetric(w, program+"_download_bytes_total", "counter", "Total size of the files downloaded.", stats.Bytes)
+	writeMetric(w, program+"_download_failures_total", "counter", "Number of failed downloads.", stats.Failures)
+	writeMetric(w, program+"_queued", "gauge", "Number of downloads waiting or in progress.", g.queued.Load())
+	writeMetric(w, program+"_throttle_delay_seconds", "gauge", "Current delay between downloads.", g.throttle.Delay().Seconds())
+	g.queueWait.write(w, program+"_queue_wait_seconds", "Time downloads waited to start, including waiting for the lock.")
+	g.lockWait.write(w, program+"_lock_wait_seconds", "Time downloads blocked acquiring the download lock.")
+	slog.Debug("got metrics request")
+}
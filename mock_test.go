@@ -0,0 +1,257 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod/lib/launcher"
+)
+
+// mockPhoto is a photo served by mockPhotos
+type mockPhoto struct {
+	realID   string // ID of the photo page lr/photo redirects to
+	name     string // file name sent in Content-Disposition
+	mimeType string // Content-Type of the file
+	data     []byte // contents of the file
+	status   int    // if set lr/photo returns this status instead of redirecting
+	noKey    bool   // set if Shift-D does nothing so only the download menu works
+	noStart  bool   // set if neither Shift-D nor the menu start the download
+	truncate bool   // set if the connection is closed before the file is finished
+}
+
+// mockPhotos is a local mock of the parts of Google Photos gphotosdl
+// uses
+//
+// It serves the home page, album pages linking to their photos,
+// lr/photo/ID which redirects to photo/REALID, and photo pages, also at
+// share/SHAREID/photo/REALID for shared albums, with an image for -wait-element to find, which start the
+// download on Shift-D or from the "More options" menu. The image URL
+// gives the original file when its size is set to "=d".
+type mockPhotos struct {
+	*httptest.Server
+	mu     sync.Mutex
+	photos map[string]*mockPhoto // by the ID used with lr/photo
+	albums map[string][]string   // IDs used with lr/photo of the photos in each album
+	lrHits atomic.Int64          // number of requests for lr/photo
+}
+
+// The page for a photo
+var mockPhotoPage = template.Must(template.New("photo").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Photo - Google Photos</title></head>
+<body>
+<img src="{{.MediaURL}}" alt="photo">
+<button aria-label="More options" onclick="document.getElementById('menu').hidden = false">More</button>
+<div id="menu" hidden><div role="menuitem" onclick="download()">Download</div></div>
+<script>
+const useKey = {{.UseKey}}, starts = {{.Starts}};
+function download() {
+	if (!starts) {
+		return;
+	}
+	const a = document.createElement("a");
+	a.href = {{.FileURL}};
+	a.download = "";
+	document.body.appendChild(a);
+	a.click();
+}
+document.addEventListener("keydown", (e) => {
+	if (useKey && e.shiftKey && e.key.toLowerCase() === "d") {
+		download();
+	}
+});
+</script>
+</body>
+</html>
+`))
+
+// The page for an album
+var mockAlbumPage = template.Must(template.New("album").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Album - Google Photos</title></head>
+<body>
+{{range .PhotoIDs}}<a href="./album/{{$.AlbumID}}/photo/{{.}}">photo</a>
+{{end}}</body>
+</html>
+`))
+
+// Start a mockPhotos serving photos, which is shut down at the end of
+// the test
+func newMockPhotos(t *testing.T, photos map[string]*mockPhoto) *mockPhotos {
+	t.Helper()
+	m := &mockPhotos{photos: photos}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<!DOCTYPE html><html><head><title>Photos - Google Photos</title></head><body>Photos</body></html>`))
+	})
+	mux.HandleFunc("GET /album/{albumID}", m.getAlbumPage)
+	mux.HandleFunc("GET /media/{spec}", m.getMedia)
+	mux.HandleFunc("GET /lr/photo/{id}", m.getLR)
+	mux.HandleFunc("GET /photo/{realID}", m.getPhotoPage)
+	mux.HandleFunc("GET /share/{shareID}/photo/{realID}", m.getPhotoPage)
+	mux.HandleFunc("GET /file/{realID}", m.getFile)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// Find the photo with the real ID given
+func (m *mockPhotos) byRealID(realID string) *mockPhoto {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, photo := range m.photos {
+		if photo.realID == realID {
+			return photo
+		}
+	}
+	return nil
+}
+
+// Redirect lr/photo/ID to the photo page
+func (m *mockPhotos) getLR(w http.ResponseWriter, r *http.Request) {
+	m.lrHits.Add(1)
+	m.mu.Lock()
+	photo := m.photos[r.PathValue("id")]
+	m.mu.Unlock()
+	switch {
+	case photo == nil:
+		http.NotFound(w, r)
+	case photo.status != 0:
+		http.Error(w, http.StatusText(photo.status), photo.status)
+	default:
+		http.Redirect(w, r, "/photo/"+photo.realID, http.StatusFound)
+	}
+}
+
+// Serve the photo page
+func (m *mockPhotos) getPhotoPage(w http.ResponseWriter, r *http.Request) {
+	photo := m.byRealID(r.PathValue("realID"))
+	if photo == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	err := mockPhotoPage.Execute(w, map[string]any{
+		"UseKey":  !photo.noKey,
+		"Starts":  !photo.noStart,
+		"FileURL": "/file/" + photo.realID,
+		// Like a googleusercontent.com URL for a resized photo
+		"MediaURL": "/media/" + photo.realID + "=w800-h600?googleusercontent.com",
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Serve the album page with a link to each photo
+func (m *mockPhotos) getAlbumPage(w http.ResponseWriter, r *http.Request) {
+	albumID := r.PathValue("albumID")
+	m.mu.Lock()
+	photoIDs, ok := m.albums[albumID]
+	m.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	err := mockAlbumPage.Execute(w, map[string]any{
+		"AlbumID":  albumID,
+		"PhotoIDs": photoIDs,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Serve the photo file as a download
+func (m *mockPhotos) getFile(w http.ResponseWriter, r *http.Request) {
+	photo := m.byRealID(r.PathValue("realID"))
+	if photo == nil {
+		http.NotFound(w, r)
+		return
+	}
+	servePhotoFile(w, photo)
+}
+
+// Serve the photo shown on the page, which is the original file if the
+// size is "=d"
+func (m *mockPhotos) getMedia(w http.ResponseWriter, r *http.Request) {
+	realID, size, _ := strings.Cut(r.PathValue("spec"), "=")
+	photo := m.byRealID(realID)
+	if photo == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if size != "d" {
+		w.Header().Set("Content-Type", "image/png")
+		return
+	}
+	servePhotoFile(w, photo)
+}
+
+// Send the file of photo
+func servePhotoFile(w http.ResponseWriter, photo *mockPhoto) {
+	w.Header().Set("Content-Type", photo.mimeType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+photo.name+`"`)
+	size := len(photo.data)
+	if photo.truncate {
+		// Promise more than is sent so the connection is closed early
+		size += 1 << 20
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	_, _ = w.Write(photo.data)
+}
+
+// Set *p to value for the duration of the test
+func setVar[T any](t *testing.T, p *T, value T) {
+	t.Helper()
+	old := *p
+	*p = value
+	t.Cleanup(func() {
+		*p = old
+	})
+}
+
+// Start a Gphotos with a browser logged in to m
+//
+// The test is skipped if there is no browser to run.
+func newTestGphotos(t *testing.T, m *mockPhotos) *Gphotos {
+	t.Helper()
+	path, ok := launcher.LookPath()
+	if !ok {
+		t.Skip("no browser found - install Chrome or Chromium to run this test")
+	}
+	setVar(t, &browserPath, path)
+	setVar(t, &browserRunDir, t.TempDir())
+	setVar(t, &downloadDir, t.TempDir())
+	setVar(t, &browserPrefs, "{}")
+	setVar(t, &gphotosURL, m.URL+"/")
+	setVar(t, photoURL, m.URL+"/lr/photo/")
+	setVar(t, realPhotoURL, m.URL+"/photo/")
+	setVar(t, &gphotosShareURL, m.URL+"/share/")
+	setVar(t, &gphotosAlbumURL, m.URL+"/album/")
+	setVar(t, waitTimeout, 5*time.Second)
+	setVar(t, downloadStartGrace, time.Second)
+	setVar(t, settleDelay, 0)
+	setVar(t, authTimeout, 10*time.Second)
+
+	g := New()
+	err := g.Start()
+	if err != nil {
+		t.Fatalf("failed to start browser: %v", err)
+	}
+	t.Cleanup(func() {
+		g.Close()
+		// Wait for the browser to exit before its directories are removed
+		g.launcher.Cleanup()
+	})
+	return g
+}
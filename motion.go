@@ -0,0 +1,262 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Parts of a motion photo which can be requested
+const (
+	motionOriginal = "original" // whatever the browser downloaded
+	motionStill    = "still"    // just the image
+	motionVideo    = "video"    // just the video clip
+)
+
+// errNoMotion is returned when the video of a photo without one is requested
+var errNoMotion = fmt.Errorf("photo has no motion video: %w", errKindNotFound)
+
+// Check part is a valid part of a motion photo
+func checkMotionPart(part string) error {
+	switch part {
+	case motionOriginal, motionStill, motionVideo:
+		return nil
+	}
+	return fmt.Errorf("unknown part %q: use %s, %s or %s: %w", part, motionOriginal, motionStill, motionVideo, errKindBadRequest)
+}
+
+// Extensions of the videos Google Photos may return
+//
+// These are listed here rather than using the mime package as the
+// system MIME types often don't include formats like .mov.
+var videoExtensions = map[string]bool{
+	".3gp":  true,
+	".avi":  true,
+	".m4v":  true,
+	".mkv":  true,
+	".mov":  true,
+	".mp4":  true,
+	".mpg":  true,
+	".webm": true,
+}
+
+// Returns true if name is a video judging by its extension
+func isVideo(name string) bool {
+	return videoExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// Replace the download in d with the part of the motion photo asked for
+//
+// Google Photos downloads motion photos in one of two ways:
+//
+//   - Live Photos from iPhones come as a .zip holding the still image
+//     and a separate video.
+//   - Motion photos from Android phones come as a JPEG with the video
+//     appended after the image.
+//
+// Anything else is a normal photo or video and is only returned if it
+// matches the part asked for.
+func extractMotion(d *Downloaded, part string) error {
+	if part == motionOriginal {
+		return nil
+	}
+	var (
+		name string
+		data []byte
+		err  error
+	)
+	switch ext := strings.ToLower(filepath.Ext(d.Name)); {
+	case ext == ".zip":
+		name, data, err = extractMotionZip(d.Path, part)
+	case ext == ".jpg" || ext == ".jpeg":
+		name, data, err = extractMotionJPEG(d.Path, d.Name, part)
+	case isVideo(d.Name) == (part == motionVideo):
+		return nil
+	case part == motionVideo:
+		return errNoMotion
+	default:
+		return fmt.Errorf("video has no still image: %w", errKindNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		// The download is already the part asked for
+		return nil
+	}
+	newPath := strings.TrimSuffix(d.Path, filepath.Ext(d.Path)) + "-" + part + strings.ToLower(filepath.Ext(name))
+	err = os.WriteFile(newPath, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", part, err)
+	}
+	err = setFileMode(newPath)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(d.Path)
+	if err != nil {
+		slog.Error("Failed to remove downloaded motion photo", "path", d.Path, "err", err)
+	}
+	slog.Debug("Extracted motion photo", "part", part, "name", name, "size", len(data))
+	d.Path, d.Name, d.Size = newPath, name, int64(len(data))
+	return nil
+}
+
+// Extract the part from a Live Photo zip returning its name and contents
+func extractMotionZip(path, part string) (name string, data []byte, err error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open motion photo zip: %w", err)
+	}
+	defer func() {
+		_ = zr.Close()
+	}()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || isVideo(f.Name) != (part == motionVideo) {
+			continue
+		}
+		in, err := f.Open()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to open %q in motion photo zip: %w", f.Name, err)
+		}
+		data, err = io.ReadAll(in)
+		_ = in.Close()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read %q in motion photo zip: %w", f.Name, err)
+		}
+		return filepath.Base(f.Name), data, nil
+	}
+	if part == motionVideo {
+		return "", nil, errNoMotion
+	}
+	return "", nil, errors.New("no image found in motion photo zip")
+}
+
+// Extract the part from an Android motion photo returning its name
+// and contents
+//
+// The video is an MP4 appended to the JPEG. If there is no video then
+// the JPEG is returned unchanged for the still.
+func extractMotionJPEG(path, name, part string) (string, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read motion photo: %w", err)
+	}
+	i := motionVideoStart(data)
+	if i < 0 {
+		if part == motionVideo {
+			return "", nil, errNoMotion
+		}
+		return name, nil, nil
+	}
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	if part == motionVideo {
+		return base + ".mp4", data[i:], nil
+	}
+	return name, data[:i], nil
+}
+
+// Find the offset of the video from the end of the file in the XMP
+// of a motion photo
+var (
+	xmpMicroVideoOffset = regexp.MustCompile(`MicroVideoOffset(?:="|>)(\d+)`)
+	xmpMotionPhotoItem  = regexp.MustCompile(`<Container:Item\b[^>]*Item:Semantic="MotionPhoto"[^>]*>`)
+	xmpItemLength       = regexp.MustCompile(`Item:Length="(\d+)"`)
+)
+
+// Returns the offset of the video in the motion photo data or -1 if
+// there isn't one
+//
+// This uses the offset in the XMP if the phone wrote one, and
+// otherwise looks for the ftyp box which starts the MP4 after the end
+// of the image, so nothing in the EXIF or image data can be mistaken
+// for it.
+func motionVideoStart(data []byte) int {
+	head := data[:min(len(data), exifSearchSize)]
+	var fromEnd []byte
+	if m := xmpMicroVideoOffset.FindSubmatch(head); m != nil {
+		fromEnd = m[1]
+	} else if item := xmpMotionPhotoItem.Find(head); item != nil {
+		if m := xmpItemLength.FindSubmatch(item); m != nil {
+			fromEnd = m[1]
+		}
+	}
+	if fromEnd != nil {
+		n, err := strconv.Atoi(string(fromEnd))
+		if i := len(data) - n; err == nil && n > 0 && isMP4Start(data, i) {
+			return i
+		}
+		slog.Debug("Ignoring bad motion photo video offset in XMP", "offset", string(fromEnd))
+	}
+	end := jpegEnd(data)
+	if end < 0 {
+		return -1
+	}
+	// The box size is the 4 bytes before "ftyp"
+	i := bytes.Index(data[end:], []byte("ftyp")) - 4
+	if i < 0 || !isMP4Start(data, end+i) {
+		return -1
+	}
+	return end + i
+}
+
+// Returns true if an MP4 ftyp box which fits in data starts at i
+func isMP4Start(data []byte, i int) bool {
+	if i <= 0 || i+8 > len(data) || string(data[i+4:i+8]) != "ftyp" {
+		return false
+	}
+	size := int64(binary.BigEndian.Uint32(data[i:]))
+	return size >= 8 && size <= int64(len(data)-i)
+}
+
+// Returns the offset just after the end of image marker of the JPEG in
+// data or -1 if it can't be found
+//
+// This walks the segments rather than searching for the marker as it
+// can appear in the EXIF thumbnail.
+func jpegEnd(data []byte) int {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return -1
+	}
+	i := 2
+	for i+1 < len(data) {
+		if data[i] != 0xff {
+			return -1
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff:
+			// Fill byte
+			i++
+			continue
+		case marker == 0xd9:
+			return i + 2
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			// Markers without a length
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return -1
+		}
+		i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker != 0xda {
+			continue
+		}
+		// Skip the image data after start of scan up to the next
+		// marker, ignoring stuffed 0xff bytes and restart markers
+		for i+1 < len(data) && (data[i] != 0xff || data[i+1] == 0 || (data[i+1] >= 0xd0 && data[i+1] <= 0xd7)) {
+			i++
+		}
+	}
+	return -1
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"testing"
+)
+
+// Make a JPEG segment with the given marker
+func makeSegment(marker byte, payload string) []byte {
+	b := []byte{0xff, marker}
+	b = binary.BigEndian.AppendUint16(b, uint16(2+len(payload)))
+	return append(b, payload...)
+}
+
+// Make a JPEG with the XMP xmp and some image data
+func makeXMPJPEG(xmp string) []byte {
+	return bytes.Join([][]byte{
+		[]byte("\xff\xd8"),
+		makeSegment(0xe1, "http://ns.adobe.com/xap/1.0/\x00"+xmp),
+		makeSegment(0xda, "scan"),
+		// Image data with a stuffed 0xff and a restart marker
+		[]byte("\x12\xff\x00ftyp\xff\xd0\x34"),
+		[]byte("\xff\xd9"),
+	}, nil)
+}
+
+func TestMotionVideoStart(t *testing.T) {
+	video := makeBox("ftyp", []byte("mp42\x00\x00\x00\x00"))
+	video = append(video, makeBox("mdat", []byte("video"))...)
+	plain := makeXMPJPEG("")
+	// The EXIF thumbnail may contain anything
+	thumb := makeJPEG(makeTIFF(binary.LittleEndian, "2024:06:01 12:30:45", "\x00\x00\x00\x10ftypmp42"))
+	offset := func(n int) string {
+		return `<x:xmpmeta GCamera:MicroVideoOffset="` + strconv.Itoa(n) + `"/>`
+	}
+	item := func(n int) string {
+		return `<Container:Item Item:Mime="video/mp4" Item:Semantic="MotionPhoto" Item:Length="` + strconv.Itoa(n) + `"/>`
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	withOffset := makeXMPJPEG(offset(len(video)))
+	withElement := makeXMPJPEG("<GCamera:MicroVideoOffset>" + strconv.Itoa(len(video)) + "</GCamera:MicroVideoOffset>")
+	withItem := makeXMPJPEG(item(len(video)))
+	tooBig := makeXMPJPEG(offset(1 << 30))
+	wrong := makeXMPJPEG(offset(3))
+	for _, test := range []struct {
+		name string
+		in   []byte
+		want int
+	}{
+		{"no video", plain, -1},
+		{"video", join(plain, video), len(plain)},
+		{"ftyp in EXIF", join(thumb, video), len(thumb)},
+		{"ftyp in EXIF and no video", thumb, -1},
+		{"junk before video", join(plain, []byte("MotionPhoto_Data"), video), len(plain) + 16},
+		{"XMP offset", join(withOffset, []byte("pad"), video), len(withOffset) + 3},
+		{"XMP element", join(withElement, video), len(withElement)},
+		{"XMP container item", join(withItem, video), len(withItem)},
+		{"XMP offset too big", join(tooBig, video), len(tooBig)},
+		{"XMP offset wrong", join(wrong, video), len(wrong)},
+		{"box too big", join(plain, []byte("\xff\xff\xff\xffftypmp42")), -1},
+		{"box too small", join(plain, []byte("\x00\x00\x00\x04ftypmp42")), -1},
+		{"truncated box", join(plain, []byte("\x00\x00ftyp")), -1},
+		{"not a JPEG", join([]byte("PNG"), video), -1},
+	} {
+		got := motionVideoStart(test.in)
+		if got != test.want {
+			t.Errorf("%s: want %d, got %d", test.name, test.want, got)
+		}
+	}
+}
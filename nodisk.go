@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// noDiskChunkSize is the most of the download held in memory at once
+// with -no-disk
+const noDiskChunkSize = 1 << 20
+
+// errNoDiskUnsupported is returned by endpoints which need the download on disk
+var errNoDiskUnsupported = fmt.Errorf("only /id/{photoID} is supported with -no-disk: %w", errKindNotImpl)
+
+// DownloadNoDisk downloads the photo with the ID given and sends it to w
+// without writing it to disk
+//
+// Instead of letting the browser save the download, the response is
+// intercepted and its body read from the browser in chunks of
+// noDiskChunkSize, then the browser's request is aborted. As with
+// DownloadStream, once anything has been written to w errors can't be
+// reported to the client so are just returned.
+func (g *Gphotos) DownloadNoDisk(photoID string, w http.ResponseWriter) (*Downloaded, error) {
+	d := &Downloaded{}
+	err := g.runDownload(photoID, d, func() error {
+		w.Header().Set("X-Queue-Wait-Ms", strconv.FormatInt(d.QueueWait.Milliseconds(), 10))
+		g.throttle.wait()
+		d.timings.mark("throttle")
+		err := g.preparePhoto(photoID, d)
+		if err == nil {
+			err = g.checkActivePhoto(photoID, d.RealID)
+		}
+		if err == nil {
+			if d.RealID != "" {
+				w.Header().Set("X-Real-Photo-ID", d.RealID)
+			}
+			err = g.interceptDownload(d, w)
+			d.timings.mark("stream")
+		}
+		g.finishDownload(photoID, d, err)
+		return err
+	})
+	return d, err
+}
+
+// Press Shift-D and copy the body of the download response to w
+func (g *Gphotos) interceptDownload(d *Downloaded, w http.ResponseWriter) error {
+	page, cancel := g.page.WithCancel()
+	defer cancel()
+
+	err := proto.FetchEnable{
+		Patterns: []*proto.FetchRequestPattern{{
+			URLPattern:   "*",
+			RequestStage: proto.FetchRequestStageResponse,
+		}},
+	}.Call(page)
+	if err != nil {
+		return fmt.Errorf("failed to enable request interception: %w", err)
+	}
+	defer func() {
+		err := proto.FetchDisable{}.Call(g.page)
+		if err != nil {
+			slog.Debug("Failed to disable request interception", "err", err)
+		}
+	}()
+
+	// Pass the download response to this goroutine and let all
+	// other requests carry on as normal
+	paused := make(chan *proto.FetchRequestPaused, 1)
+	go page.EachEvent(func(e *proto.FetchRequestPaused) bool {
+		if isAttachment(e.ResponseHeaders) {
+			paused <- e
+			return true
+		}
+		err := proto.FetchContinueRequest{RequestID: e.RequestID}.Call(page)
+		if err != nil {
+			slog.Debug("Failed to continue request", "url", e.Request.URL, "err", err)
+		}
+		return false
+	})()
+
+	// Shift-D or the download menu to download
+	e, err := triggerDownload(g, paused)
+	if err != nil {
+		return err
+	}
+
+	// Stop the browser getting the download whatever happens
+	defer func() {
+		err := proto.FetchFailRequest{RequestID: e.RequestID, ErrorReason: proto.NetworkErrorReasonAborted}.Call(page)
+		if err != nil {
+			slog.Debug("Failed to abort download request", "err", err)
+		}
+	}()
+
+	if e.ResponseStatusCode != nil && *e.ResponseStatusCode != http.StatusOK {
+		headers := make([]any, 0, len(e.ResponseHeaders))
+		for _, h := range e.ResponseHeaders {
+			headers = append(headers, slog.String(h.Name, h.Value))
+		}
+		slog.Debug("Download failed", "url", e.Request.URL, "status", *e.ResponseStatusCode, slog.Group("headers", headers...))
+		return fmt.Errorf("download failed: %w", upstreamError(*e.ResponseStatusCode))
+	}
+	size, _ := strconv.ParseInt(fetchHeader(e.ResponseHeaders, "Content-Length"), 10, 64)
+	err = checkFileSize(size)
+	if err != nil {
+		return err
+	}
+	if _, params, err := mime.ParseMediaType(fetchHeader(e.ResponseHeaders, "Content-Disposition")); err == nil {
+		d.Name = params["filename"]
+	}
+	slog.Debug("Intercepted download", "url", e.Request.URL, "name", d.Name, "size", size)
+
+	stream, err := proto.FetchTakeResponseBodyAsStream{RequestID: e.RequestID}.Call(page)
+	if err != nil {
+		return fmt.Errorf("failed to read download: %w", err)
+	}
+	defer func() {
+		_ = proto.IOClose{Handle: stream.Stream}.Call(page)
+	}()
+
+	// Send the headers before the first data
+	for _, name := range []string{"Content-Type", "Content-Length"} {
+		if value := fetchHeader(e.ResponseHeaders, name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	if d.Name != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": d.Name}))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	var sent int64
+	chunkSize := noDiskChunkSize
+	for {
+		chunk, err := proto.IORead{Handle: stream.Stream, Size: &chunkSize}.Call(page)
+		if err != nil {
+			return fmt.Errorf("failed to read download: %w", err)
+		}
+		data := []byte(chunk.Data)
+		if chunk.Base64Encoded {
+			data, err = base64.StdEncoding.DecodeString(chunk.Data)
+			if err != nil {
+				return fmt.Errorf("failed to decode download: %w", err)
+			}
+		}
+		n, err := w.Write(data)
+		sent += int64(n)
+		if err != nil {
+			return fmt.Errorf("failed to send download: %w", err)
+		}
+		err = checkFileSize(sent)
+		if err != nil {
+			return err
+		}
+		if chunk.EOF {
+			break
+		}
+	}
+	if size > 0 && sent != size {
+		return errors.New("download was truncated")
+	}
+	d.Size = sent
+	slog.Debug("No disk download successful", "size", sent, "name", d.Name)
+	return nil
+}
+
+// Returns true if the response headers are for a download
+func isAttachment(headers []*proto.FetchHeaderEntry) bool {
+	disposition, _, _ := strings.Cut(fetchHeader(headers, "Content-Disposition"), ";")
+	return strings.EqualFold(strings.TrimSpace(disposition), "attachment")
+}
+
+// Returns the value of the header name or "" if not found
+func fetchHeader(headers []*proto.FetchHeaderEntry, name string) string {
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// Serve a photo ID without writing it to disk
+func (g *Gphotos) getIDNoDisk(w http.ResponseWriter, photoID, requestID string) {
+	rw := &responseWriter{ResponseWriter: w}
+	d, err := g.DownloadNoDisk(photoID, rw)
+	if err != nil {
+		slog.Error("No disk download failed", "id", photoID, "sent", rw.n, "err", err)
+		if !rw.wroteHeader {
+			writeError(w, photoID, err)
+		}
+		return
+	}
+	slog.Info("Sent photo without using disk", "id", photoID, "size", rw.n)
+	logTimings(requestID, photoID, d)
+}
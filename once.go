@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Download the photo with the ID given to output and return
+//
+// If output is blank then the original file name of the photo is used
+// and if it is "-" then the photo is written to stdout.
+func (g *Gphotos) downloadOnce(photoID, output string) error {
+	d, err := g.Download(photoID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := os.Remove(d.Path)
+		if err != nil {
+			slog.Error("Failed to remove downloaded photo", "id", photoID, "path", d.Path, "err", err)
+		}
+	}()
+	if output == "" {
+		output = d.Name
+		if output == "" {
+			output = photoID
+		}
+	}
+	if output == "-" {
+		in, err := os.Open(d.Path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = in.Close()
+		}()
+		_, err = io.Copy(os.Stdout, in)
+		if err != nil {
+			return fmt.Errorf("failed to write photo to stdout: %w", err)
+		}
+		return nil
+	}
+	err = copyFile(output, d.Path)
+	if err != nil {
+		return fmt.Errorf("failed to write photo: %w", err)
+	}
+	slog.Info("Downloaded photo", "id", photoID, "output", output, "size", d.Size)
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Download the photos with the IDs read one per line from the file
+// given ("-" for stdin) into -output-dir
+//
+// Blank lines and lines starting with # are ignored. This returns an
+// error if any of the photos failed.
+func (g *Gphotos) downloadIDsToDir(idsFile string) error {
+	var in io.Reader = os.Stdin
+	if idsFile != "-" {
+		f, err := os.Open(idsFile)
+		if err != nil {
+			return fmt.Errorf("failed to open -ids: %w", err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		in = f
+	}
+	var failed, total int
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		photoID := strings.TrimSpace(scanner.Text())
+		if photoID == "" || strings.HasPrefix(photoID, "#") {
+			continue
+		}
+		total++
+		item := g.saveToDir(photoID, *outputDir)
+		if item.Error != "" {
+			failed++
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		return fmt.Errorf("failed to read -ids: %w", err)
+	}
+	slog.Info("Finished downloading to directory", "dir", *outputDir, "photos", total, "failed", failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d photos failed", failed, total)
+	}
+	return nil
+}
+
+// Serve a batch of photo IDs by downloading them into -output-dir
+//
+// The request body is a JSON array of photo IDs as for the tar stream
+// and the response is the manifest giving the path of each photo in
+// the directory.
+func (g *Gphotos) batchToDir(w http.ResponseWriter, r *http.Request, photoIDs []string) {
+	manifest := make([]BatchItem, 0, len(photoIDs))
+	for _, photoID := range photoIDs {
+		if r.Context().Err() != nil {
+			slog.Error("Batch request cancelled", "err", r.Context().Err())
+			return
+		}
+		manifest = append(manifest, g.saveToDir(photoID, *outputDir))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(manifest)
+	if err != nil {
+		slog.Debug("Failed to write batch manifest", "err", err)
+	}
+}
+
+// Download photoID and put it in dir under its original name, dated
+// when it was taken
+//
+// If a different photo already has that name then the photo ID is
+// added to the name to keep both.
+func (g *Gphotos) saveToDir(photoID, dir string) (item BatchItem) {
+	item = BatchItem{ID: photoID, Status: http.StatusOK}
+	d, err := g.Download(photoID)
+	if err != nil {
+		slog.Error("Download to directory failed", "id", photoID, "err", err)
+		item.setError(err)
+		return item
+	}
+	// Shared photo IDs have "/" and "?" in so use the photo's own ID
+	fileID := pagePhotoID(photoID)
+	name := d.Name
+	if name == "" {
+		name = fileID
+	}
+	name = filepath.Base(name)
+	dst := filepath.Join(dir, name)
+	if _, err := os.Lstat(dst); err == nil {
+		ext := filepath.Ext(name)
+		name = strings.TrimSuffix(name, ext) + "-" + fileID + ext
+		dst = filepath.Join(dir, name)
+	}
+	err = moveFile(dst, d.Path)
+	if err != nil {
+		slog.Error("Failed to move photo to directory", "id", photoID, "path", dst, "err", err)
+		item.setError(err)
+		return item
+	}
+	// Date the file by when the photo was taken, like Google Takeout
+	if taken := captureTime(dst); !taken.IsZero() {
+		err = os.Chtimes(dst, taken, taken)
+		if err != nil {
+			slog.Warn("Failed to set the time of photo in directory", "id", photoID, "path", dst, "err", err)
+		}
+	}
+	item.Path = name
+	item.Size = d.Size
+	slog.Info("Downloaded photo to directory", "id", photoID, "path", dst)
+	return item
+}
+
+// Move the file at src to dst, copying it if they are on different
+// file systems
+func moveFile(dst, src string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) || errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	err = copyFile(dst, src)
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
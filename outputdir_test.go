@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveToDirShared(t *testing.T) {
+	photos := testPhotos()
+	m := newMockPhotos(t, photos)
+	g := newTestGphotos(t, m)
+	dir := t.TempDir()
+	photoID := sharedPhotoID("share1", "real1", "a key/with?odd=chars")
+
+	// The second copy clashes so gets the ID added to its name
+	for _, want := range []string{"IMG_0001.JPG", "IMG_0001-real1.JPG"} {
+		item := g.saveToDir(photoID, dir)
+		if item.Error != "" {
+			t.Fatalf("save of %q failed: %s", photoID, item.Error)
+		}
+		if item.Path != want {
+			t.Errorf("want path %q, got %q", want, item.Path)
+		}
+		got, err := os.ReadFile(filepath.Join(dir, item.Path))
+		if err != nil {
+			t.Fatalf("failed to read saved photo: %v", err)
+		}
+		if string(got) != string(photos["photo1"].data) {
+			t.Errorf("saved photo doesn't match the one served")
+		}
+	}
+}
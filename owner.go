@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// File holding the PID of the gphotosdl using a directory, like the
+// browser profile or the download directory
+const ownerFile = "gphotosdl.pid"
+
+// Record this gphotosdl as the owner of dir
+func writeOwner(dir string) error {
+	return os.WriteFile(filepath.Join(dir, ownerFile), []byte(strconv.Itoa(os.Getpid())+"\n"), 0600)
+}
+
+// Returns the PID of the gphotosdl which owns dir or 0 if it isn't
+// known
+func readOwner(dir string) int {
+	data, err := os.ReadFile(filepath.Join(dir, ownerFile))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// Returns the PID of the owner of dir if it is a gphotosdl other than
+// this one which is still running
+func otherOwner(dir string) (int, bool) {
+	owner := readOwner(dir)
+	if owner <= 0 || owner == os.Getpid() || !isGphotosdl(owner) {
+		return 0, false
+	}
+	return owner, true
+}
+
+// Stop recording this gphotosdl as the owner of dir
+func removeOwner(dir string) error {
+	if readOwner(dir) != os.Getpid() {
+		return nil
+	}
+	err := os.Remove(filepath.Join(dir, ownerFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Arrange for the page to be parked according to -park once no
+// downloads have happened for -park-after
+//
+// This must be called with the lock held.
+func (g *Gphotos) schedulePark() {
+	if *parkMode == "none" {
+		return
+	}
+	if g.parkTimer == nil {
+		g.parkTimer = time.AfterFunc(*parkAfter, g.parkPage)
+	} else {
+		g.parkTimer.Reset(*parkAfter)
+	}
+}
+
+// Navigate away from the last photo to a lighter page so the browser
+// can free the memory it used
+func (g *Gphotos) parkPage() {
+	if g.restarting.Load() || g.closing.Load() {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.logPageMemory("Before parking page")
+	u := "about:blank"
+	if *parkMode == "home" {
+		u = accountURL(gphotosURL)
+	}
+	err := g.page.Navigate(u)
+	if err == nil {
+		err = g.waitPage()
+	}
+	if err != nil {
+		slog.Error("Failed to park page", "url", u, "err", err)
+		return
+	}
+	g.logPageMemory("Parked page")
+}
+
+// Log the JavaScript heap used by the page at debug level so the
+// effect of -park can be measured
+func (g *Gphotos) logPageMemory(msg string) {
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	heap, err := proto.RuntimeGetHeapUsage{}.Call(g.page)
+	if err != nil {
+		slog.Debug("Failed to read page memory", "err", err)
+		return
+	}
+	slog.Debug(msg, "js_heap_used", fmt.Sprintf("%.1f MiB", heap.UsedSize/(1<<20)), "js_heap_total", fmt.Sprintf("%.1f MiB", heap.TotalSize/(1<<20)))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Returns the photo ID for a Google Photos URL copied from a browser
+//
+// These forms are accepted, optionally with /u/N after the host:
+//
+//	https://photos.google.com/lr/photo/PHOTOID
+//	https://photos.google.com/photo/PHOTOID
+//	https://photos.google.com/album/ALBUMID/photo/PHOTOID
+//	https://photos.google.com/share/SHAREID/photo/PHOTOID?key=KEY
+func photoIDFromURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %v: %w", err, errKindBadRequest)
+	}
+	home, _ := url.Parse(gphotosURL)
+	if u.Scheme != home.Scheme || u.Host != home.Host {
+		return "", fmt.Errorf("URL must start with %q: %w", gphotosURL, errKindBadRequest)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "u" {
+		parts = parts[2:]
+	}
+	switch {
+	case len(parts) == 3 && parts[0] == "lr" && parts[1] == "photo":
+		return parts[2], nil
+	case len(parts) == 2 && parts[0] == "photo":
+		return parts[1], nil
+	case len(parts) == 4 && parts[0] == "album" && parts[2] == "photo":
+		return parts[3], nil
+	case len(parts) == 4 && parts[0] == "share" && parts[2] == "photo":
+		return sharedPhotoID(parts[1], parts[3], u.Query().Get("key")), nil
+	}
+	return "", fmt.Errorf("URL %q isn't a photo: %w", rawURL, errKindBadRequest)
+}
+
+// Serve the photo at the Google Photos URL in the "url" query parameter
+func (g *Gphotos) getURL(w http.ResponseWriter, r *http.Request) {
+	rawURL := strings.TrimSpace(r.URL.Query().Get("url"))
+	photoID, err := photoIDFromURL(rawURL)
+	if err != nil {
+		slog.Error("Bad photo URL", "url", rawURL, "err", err)
+		writeError(w, "", err)
+		return
+	}
+	g.servePhoto(w, r, photoID, normalizeID(r.URL.Query().Get("part")))
+}
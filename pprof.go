@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Start the profiling web server on the -pprof address
+//
+// This is kept separate from the main web server so the profiling
+// endpoints are never exposed by accident.
+func startPprof() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{
+		Addr:              *pprofAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	go func() {
+		slog.Info("Starting profiling server", "url", "http://"+*pprofAddr+"/debug/pprof/")
+		err := server.ListenAndServe()
+		if err != nil {
+			slog.Error("Error starting profiling server", "err", err)
+		}
+	}()
+}
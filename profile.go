@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Files in a Chrome profile directory which hold the login session
+var profileFiles = []string{
+	"Cookies",
+	"Cookies-journal",
+	filepath.Join("Network", "Cookies"),
+	filepath.Join("Network", "Cookies-journal"),
+	"Login Data",
+	"Login Data-journal",
+	"Web Data",
+	"Web Data-journal",
+}
+
+// Import the login session from an existing Chrome profile at src
+//
+// src can either be a Chrome user data directory, in which case the
+// Default profile is used, or a profile directory within it.
+func importProfile(src string) error {
+	userDataDir, profileDir := src, filepath.Join(src, "Default")
+	if !exists(filepath.Join(src, "Local State")) {
+		userDataDir, profileDir = filepath.Dir(src), src
+	}
+	if !exists(filepath.Join(userDataDir, "Local State")) {
+		return fmt.Errorf("%q doesn't look like a Chrome profile - can't find \"Local State\"", src)
+	}
+	slog.Debug("Importing profile", "user_data_dir", userDataDir, "profile_dir", profileDir)
+	if exists(filepath.Join(userDataDir, "SingletonLock")) {
+		slog.Warn("The browser using this profile looks like it is running - close it first so the cookies are saved")
+	}
+
+	// Local State holds the cookie encryption key on some OSes
+	err := copyFile(filepath.Join(browserConfig, "Local State"), filepath.Join(userDataDir, "Local State"))
+	if err != nil {
+		return err
+	}
+	copied := 0
+	for _, name := range profileFiles {
+		err = copyFile(filepath.Join(browserConfig, "Default", name), filepath.Join(profileDir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		slog.Debug("Imported profile file", "name", name)
+		copied++
+	}
+	if copied == 0 {
+		return fmt.Errorf("no cookies found in profile %q", profileDir)
+	}
+
+	// The cookies are encrypted with a key which may not come with them
+	switch runtime.GOOS {
+	case "darwin":
+		slog.Warn("On macOS the cookies are encrypted with a key from the Keychain. Run gphotosdl with -mock-keychain=false to use the imported profile. If it came from a different browser (eg Chrome vs Chromium) to the one gphotosdl uses then the cookies can't be decrypted and you will need to use -login instead.", "browser_path", browserPath)
+	case "linux":
+		slog.Warn("On Linux the cookies may be encrypted with a key from the desktop keyring. If gphotosdl can't read the same keyring (eg when run as a service) then the cookies can't be decrypted and you will need to use -login instead.")
+	case "windows":
+		slog.Warn("On Windows the cookie encryption key only works for the same Windows user and recent Chrome versions may bind it to the Chrome install. If the login doesn't work then use -login instead.")
+	}
+	slog.Info("Imported profile", "from", profileDir, "files", copied)
+	return nil
+}
+
+// Returns true if path exists
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Copy the file at src to dst, creating any directories needed
+func copyFile(dst, src string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	err = os.MkdirAll(filepath.Dir(dst), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make directory: %w", err)
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer func() {
+		closeErr := out.Close()
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to close file: %w", closeErr)
+		}
+	}()
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return fmt.Errorf("failed to copy %q: %w", src, err)
+	}
+	return nil
+}
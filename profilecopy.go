@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directories in a Chrome user data directory which are only caches so
+// aren't worth copying for -profile-mode copy
+var profileCopySkipDirs = map[string]bool{
+	"Cache":               true,
+	"Code Cache":          true,
+	"GPUCache":            true,
+	"GrShaderCache":       true,
+	"GraphiteDawnCache":   true,
+	"ShaderCache":         true,
+	"DawnCache":           true,
+	"Crashpad":            true,
+	"component_crx_cache": true,
+	"CacheStorage":        true,
+}
+
+// Copy the browser profile to a throwaway directory for the browser to
+// run against, returning its path
+//
+// This is done at each launch, replacing the copy from the last one,
+// so a restart starts from the login in browserConfig again. The copy
+// goes in the download directory so it is removed when gphotosdl
+// exits. The profile in browserConfig is left untouched, so several
+// gphotosdl can share one login without fighting over the profile
+// lock.
+//
+// "Local State" holds the key the cookies are encrypted with so it is
+// copied along with the profile. The key is itself protected by the
+// OS for the current user, so the copy is only usable by the same user
+// on the same machine, with the same -mock-keychain setting.
+func copyBrowserProfile() (string, error) {
+	if !exists(filepath.Join(browserConfig, "Local State")) {
+		return "", fmt.Errorf("no browser profile to copy in %q - run with -login first", browserConfig)
+	}
+	dst := filepath.Join(downloadDir, "browser")
+	err := os.RemoveAll(dst)
+	if err != nil {
+		return "", fmt.Errorf("failed to remove old copy of browser profile: %w", err)
+	}
+	files := 0
+	err = filepath.WalkDir(browserConfig, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			if profileCopySkipDirs[name] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Skip the profile lock and anything else which isn't a file
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, "Singleton") {
+			return nil
+		}
+		rel, err := filepath.Rel(browserConfig, path)
+		if err != nil {
+			return err
+		}
+		err = copyFile(filepath.Join(dst, rel), path)
+		if err != nil {
+			return err
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to copy browser profile: %w", err)
+	}
+	slog.Debug("Copied browser profile", "from", browserConfig, "to", dst, "files", files)
+	return dst, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// gphotosQuotaURL is the page showing the storage used by the account
+const gphotosQuotaURL = "https://photos.google.com/quotamanagement"
+
+// Match the storage used on the quota page, eg "5.2 GB of 15 GB used"
+var quotaRe = regexp.MustCompile(`(?i)([\d.,]+)\s*([KMGT]?B)\s+of\s+([\d.,]+)\s*([KMGT]?B)\s+used`)
+
+// errQuotaNotFound is returned if the storage can't be found on the quota page
+var errQuotaNotFound = fmt.Errorf("couldn't find storage used on the quota page: %w", errKindBadPage)
+
+// Quota is the storage used by the account
+type Quota struct {
+	Used       string `json:"used"`        // as shown by Google, eg "5.2 GB"
+	Total      string `json:"total"`       // as shown by Google, eg "15 GB"
+	UsedBytes  int64  `json:"used_bytes"`  // approximate as Google rounds the figures
+	TotalBytes int64  `json:"total_bytes"` // approximate as Google rounds the figures
+}
+
+// Serve the storage quota of the account as JSON
+func (g *Gphotos) getQuota(w http.ResponseWriter, r *http.Request) {
+	slog.Info("got quota request")
+	quota, err := g.CheckQuota()
+	if err != nil {
+		slog.Error("Quota check failed", "err", err)
+		writeError(w, "", err)
+		return
+	}
+	slog.Info("Quota check", "used", quota.Used, "total", quota.Total)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(quota)
+	if err != nil {
+		slog.Debug("Failed to write quota response", "err", err)
+	}
+}
+
+// CheckQuota navigates to the storage page and reads the storage used
+//
+// This relies on the page being in English so works best with the
+// default -lang.
+func (g *Gphotos) CheckQuota() (*Quota, error) {
+	err := g.checkBrowser()
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	err = g.page.Navigate(accountURL(gphotosQuotaURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to navigate to quota page: %w", err)
+	}
+	err = g.waitPage()
+	if err != nil {
+		return nil, fmt.Errorf("quota page load: %w", err)
+	}
+	info, err := g.page.Info()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page info: %w", err)
+	}
+	err = checkAccountChooser(info.URL)
+	if err != nil {
+		return nil, err
+	}
+	res, err := g.page.Timeout(*waitTimeout).Eval(`() => document.body ? document.body.innerText : ""`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota page: %w", err)
+	}
+	match := quotaRe.FindStringSubmatch(res.Value.Str())
+	if match == nil {
+		return nil, errQuotaNotFound
+	}
+	return &Quota{
+		Used:       match[1] + " " + match[2],
+		Total:      match[3] + " " + match[4],
+		UsedBytes:  parseQuotaSize(match[1], match[2]),
+		TotalBytes: parseQuotaSize(match[3], match[4]),
+	}, nil
+}
+
+// Parse a size shown by Google, eg "5.2" "GB", returning bytes or 0
+// if it can't be parsed
+//
+// Google shows sizes using binary units, so 1 GB is 1024 MB.
+func parseQuotaSize(number, unit string) int64 {
+	f, err := strconv.ParseFloat(strings.ReplaceAll(number, ",", ""), 64)
+	if err != nil {
+		return 0
+	}
+	shift := strings.Index("BKMGT", strings.ToUpper(unit[:1]))
+	return int64(f * float64(int64(1)<<(10*shift)))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting downloads to -max-per-hour
+//
+// The bucket starts full and refills steadily, so up to -max-per-hour
+// downloads can be done at once, then they are spread out over the
+// hour.
+type rateLimiter struct {
+	mu     sync.Mutex
+	tokens float64   // downloads which can be started now
+	last   time.Time // when tokens was last brought up to date
+}
+
+// Bring the tokens up to date, returning the bucket size
+//
+// This must be called with the lock held.
+func (l *rateLimiter) refill() float64 {
+	size := float64(*maxPerHour)
+	now := time.Now()
+	if l.last.IsZero() {
+		l.tokens = size
+	} else {
+		l.tokens = min(size, l.tokens+now.Sub(l.last).Hours()*size)
+	}
+	l.last = now
+	return size
+}
+
+// Take a token for a download, returning an error saying how long to
+// wait if there are none left
+func (l *rateLimiter) take() error {
+	if *maxPerHour <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	size := l.refill()
+	if l.tokens >= 1 {
+		l.tokens--
+		return nil
+	}
+	wait := time.Duration((1 - l.tokens) / size * float64(time.Hour))
+	err := fmt.Errorf("more than -max-per-hour %d downloads: %w", *maxPerHour, errKindRateLimited)
+	return withRetryAfter(err, wait)
+}
+
+// Give back a token taken for a download which failed before it
+// reached the browser
+func (l *rateLimiter) giveBack() {
+	if *maxPerHour <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	size := l.refill()
+	l.tokens = min(size, l.tokens+1)
+}
+
+// Returns the number of downloads which can be started now, or -1 if
+// there is no limit
+func (l *rateLimiter) remaining() int {
+	if *maxPerHour <= 0 {
+		return -1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	return int(l.tokens)
+}
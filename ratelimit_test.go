@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		perHour   int
+		takes     int           // tokens taken straight away
+		givenBack int           // tokens then given back
+		elapsed   time.Duration // time which then passes
+		wantOK    bool          // whether the next take succeeds
+		wantLeft  int           // remaining after the next take
+		wantRetry time.Duration // Retry-After if the next take fails
+	}{
+		{name: "unlimited", perHour: 0, takes: 1000, wantOK: true, wantLeft: -1},
+		{name: "under limit", perHour: 10, takes: 5, wantOK: true, wantLeft: 4},
+		{name: "last token", perHour: 10, takes: 9, wantOK: true, wantLeft: 0},
+		{name: "over limit", perHour: 10, takes: 10, wantRetry: 6 * time.Minute},
+		{name: "partly refilled", perHour: 10, takes: 10, elapsed: 3 * time.Minute, wantRetry: 3 * time.Minute},
+		{name: "refilled", perHour: 10, takes: 10, elapsed: 6 * time.Minute, wantOK: true, wantLeft: 0},
+		{name: "refill capped", perHour: 10, takes: 10, elapsed: 24 * time.Hour, wantOK: true, wantLeft: 9},
+		{name: "given back", perHour: 10, takes: 10, givenBack: 1, wantOK: true, wantLeft: 0},
+		{name: "give back capped", perHour: 10, takes: 1, givenBack: 5, wantOK: true, wantLeft: 9},
+		{name: "unlimited give back", perHour: 0, givenBack: 1, wantOK: true, wantLeft: -1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			setVar(t, maxPerHour, test.perHour)
+			var l rateLimiter
+			for i := 0; i < test.takes; i++ {
+				err := l.take()
+				if err != nil {
+					t.Fatalf("take %d: unexpected error: %v", i, err)
+				}
+			}
+			for i := 0; i < test.givenBack; i++ {
+				l.giveBack()
+			}
+			// Make the time pass
+			l.last = l.last.Add(-test.elapsed)
+
+			err := l.take()
+			if test.wantOK {
+				if err != nil {
+					t.Fatalf("want the take to succeed, got %v", err)
+				}
+				if got := l.remaining(); got != test.wantLeft {
+					t.Errorf("want %d remaining, got %d", test.wantLeft, got)
+				}
+				return
+			}
+			if kindOf(err) != errKindRateLimited {
+				t.Fatalf("want %v, got %v", errKindRateLimited, err)
+			}
+			after, ok := retryAfterOf(err, errKindRateLimited)
+			if !ok {
+				t.Fatalf("want a Retry-After, got %v", err)
+			}
+			if diff := after - test.wantRetry; diff < -time.Second || diff > time.Second {
+				t.Errorf("want Retry-After %v, got %v", test.wantRetry, after)
+			}
+		})
+	}
+}
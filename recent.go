@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRecentErrors is the number of download failures kept for /errors
+const maxRecentErrors = 50
+
+// RecentError is a download failure returned by /errors
+type RecentError struct {
+	Time           time.Time `json:"time"`
+	ID             string    `json:"id"`
+	Code           string    `json:"code"`
+	Status         int       `json:"status"`
+	UpstreamStatus int       `json:"upstream_status,omitempty"`
+	Error          string    `json:"error"`
+}
+
+// recentErrors is a ring buffer of the last maxRecentErrors download
+// failures
+type recentErrors struct {
+	mu     sync.Mutex
+	errors []RecentError
+	next   int // index to write the next error to once errors is full
+}
+
+// Record err for photoID if it isn't nil
+func (r *recentErrors) add(photoID string, err error) {
+	if err == nil {
+		return
+	}
+	kind := kindOf(err)
+	e := RecentError{
+		Time:           time.Now(),
+		ID:             photoID,
+		Code:           kind.Code,
+		Status:         kind.Status,
+		UpstreamStatus: upstreamStatusOf(err),
+		Error:          err.Error(),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.errors) < maxRecentErrors {
+		r.errors = append(r.errors, e)
+		return
+	}
+	r.errors[r.next] = e
+	r.next = (r.next + 1) % maxRecentErrors
+}
+
+// Returns the recorded errors, most recent first
+func (r *recentErrors) list() []RecentError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]RecentError, 0, len(r.errors))
+	for i := range r.errors {
+		j := (r.next - 1 - i + 2*len(r.errors)) % len(r.errors)
+		list = append(list, r.errors[j])
+	}
+	return list
+}
+
+// Serve the recent download failures as JSON, most recent first
+func (g *Gphotos) getErrors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(g.errors.list())
+	if err != nil {
+		slog.Debug("Failed to write errors response", "err", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// recoveryReason is why the browser needs recovering after a failure
+type recoveryReason int
+
+// Reasons for recovering the browser, cheapest to fix first
+const (
+	reasonHang         recoveryReason = iota // the page or a download timed out
+	reasonPhotoError                         // the download of a single photo went wrong
+	reasonDisconnected                       // the browser disconnected
+	reasonCrashed                            // the browser tab crashed, eg out of memory
+)
+
+// String returns the reason for logging
+func (r recoveryReason) String() string {
+	switch r {
+	case reasonHang:
+		return "hang"
+	case reasonPhotoError:
+		return "photo error"
+	case reasonDisconnected:
+		return "browser disconnected"
+	case reasonCrashed:
+		return "browser tab crashed"
+	}
+	return fmt.Sprintf("unknown (%d)", int(r))
+}
+
+// Returns true if the browser needs relaunching to recover from r
+// rather than just reloading the page
+func (r recoveryReason) hard() bool {
+	return r >= reasonDisconnected
+}
+
+// Returns the reason to recover the browser after a download failed
+// with err, or false if the browser doesn't need recovering
+//
+// Errors which say something about the photo, like Google returning an
+// error for it, leave the page alone as reloading it won't help.
+func recoveryFor(err error) (recoveryReason, bool) {
+	if err == nil {
+		return 0, false
+	}
+	switch kindOf(err) {
+	case errKindTimeout:
+		return reasonHang, true
+	case errKindInternal, errKindBadPage:
+		return reasonPhotoError, true
+	}
+	return 0, false
+}
+
+// Recover the browser after a failure in the cheapest way which
+// should fix it
+//
+// Transient failures reload the page which only takes a moment, and
+// hard failures relaunch the browser. If reloading the page doesn't
+// work then the browser is relaunched too.
+//
+// This must be called without the lock held.
+func (g *Gphotos) recover(reason recoveryReason) {
+	if g.closing.Load() || g.restarting.Load() || g.degraded.Load() {
+		return
+	}
+	if reason.hard() {
+		slog.Error("Browser has gone away - restarting it", "reason", reason)
+		g.restartBrowser(true)
+		return
+	}
+	slog.Warn("Download failed - reloading the page", "reason", reason)
+	g.mu.Lock()
+	err := g.reloadPage()
+	g.mu.Unlock()
+	if err == nil {
+		slog.Info("Reloaded the page")
+		return
+	}
+	if g.closing.Load() {
+		return
+	}
+	slog.Error("Failed to reload the page - restarting the browser", "reason", reason, "err", err)
+	g.restartBrowser(true)
+}
+
+// Navigate the page to the Google Photos home page and wait for it to
+// load
+//
+// This must be called with the lock held.
+func (g *Gphotos) reloadPage() error {
+	err := g.page.Navigate(accountURL(gphotosURL))
+	if err == nil {
+		err = g.waitPage()
+	}
+	return err
+}
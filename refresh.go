@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Load the Google Photos home page every interval while idle so the
+// login session is kept fresh
+//
+// Google extends sessions which are in use, so this stops the login
+// expiring when the proxy is left running for days with gaps between
+// transfers.
+func (g *Gphotos) refreshSessionEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		g.refreshSession()
+	}
+}
+
+// Load the Google Photos home page to refresh the login cookies,
+// unless a download is in progress or waiting
+//
+// Downloads keep the session fresh themselves, so there is no need to
+// hold them up.
+func (g *Gphotos) refreshSession() {
+	if g.checkBrowser() != nil || g.queued.Load() > 0 {
+		return
+	}
+	if !g.mu.TryLock() {
+		return
+	}
+	defer g.mu.Unlock()
+	err := g.page.Navigate(accountURL(gphotosURL))
+	if err == nil {
+		err = g.waitPage()
+	}
+	if err != nil {
+		slog.Error("Failed to refresh login session", "err", err)
+		return
+	}
+	info, err := g.page.Info()
+	if err != nil {
+		slog.Error("Failed to read page info after refreshing login session", "err", err)
+		return
+	}
+	if !isAuthenticatedURL(info.URL) {
+		slog.Error("Browser is no longer logged in - re-run with -login", "url", info.URL)
+		return
+	}
+	slog.Debug("Refreshed login session")
+	g.schedulePark()
+}
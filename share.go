@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// gphotosShareURL is the prefix of the URLs of photos in shared albums
+//
+// This is a variable so the tests can point it at a mock.
+var gphotosShareURL = "https://photos.google.com/share/"
+
+// Returns the photo ID used internally for a photo in a shared album
+//
+// This is the path of the photo under gphotosShareURL, eg
+// SHAREID/photo/PHOTOID?key=KEY, so it can be passed to Download like
+// any other photo ID.
+func sharedPhotoID(shareID, photoID, key string) string {
+	id := shareID + "/photo/" + photoID
+	if key != "" {
+		id += "?key=" + url.QueryEscape(key)
+	}
+	return id
+}
+
+// Returns true if photoID is one made by sharedPhotoID
+func isSharedPhotoID(photoID string) bool {
+	return strings.Contains(photoID, "/photo/")
+}
+
+// Returns the ID of the photo the browser shows for photoID
+//
+// This is photoID itself unless it is a shared photo ID, in which case
+// it is the photo part of it.
+func pagePhotoID(photoID string) string {
+	if !isSharedPhotoID(photoID) {
+		return photoID
+	}
+	_, photoID, _ = strings.Cut(photoID, "/photo/")
+	photoID, _, _ = strings.Cut(photoID, "?")
+	return photoID
+}
+
+// Serve a photo from a shared album
+//
+// The share link of an album looks like
+// https://photos.google.com/share/SHAREID?key=KEY and its photos are
+// at /share/SHAREID/photo/PHOTOID?key=KEY which is the path this
+// serves.
+func (g *Gphotos) getShared(w http.ResponseWriter, r *http.Request) {
+	photoID := sharedPhotoID(normalizeID(r.PathValue("shareID")), normalizeID(r.PathValue("photoID")), strings.TrimSpace(r.URL.Query().Get("key")))
+	slog.Debug("got shared photo request", "id", photoID)
+	g.servePhoto(w, r, photoID, normalizeID(r.PathValue("part")))
+}
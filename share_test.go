@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestPagePhotoID(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"AF1Qip", "AF1Qip"},
+		{sharedPhotoID("share1", "photo1", ""), "photo1"},
+		{sharedPhotoID("share1", "photo1", "a key/with?odd=chars"), "photo1"},
+	} {
+		got := pagePhotoID(test.in)
+		if got != test.want {
+			t.Errorf("%q: want %q, got %q", test.in, test.want, got)
+		}
+	}
+}
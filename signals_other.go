@@ -7,3 +7,7 @@ import (
 )
 
 var exitSignals = []os.Signal{os.Interrupt}
+
+var dumpSignals = []os.Signal{}
+
+var logLevelSignals = []os.Signal{}
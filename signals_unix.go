@@ -8,3 +8,7 @@ import (
 )
 
 var exitSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM} // Not syscall.SIGQUIT as we want the default behaviour
+
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
+
+var logLevelSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// downloadStats counts the downloads served since the browser first
+// logged in
+type downloadStats struct {
+	since    atomic.Int64 // unix nanoseconds the session started
+	files    atomic.Int64
+	bytes    atomic.Int64
+	failures atomic.Int64
+}
+
+// Stats is the JSON returned by /stats
+type Stats struct {
+	Since          time.Time `json:"since"`            // when the browser first logged in
+	Files          int64     `json:"files"`            // number of files downloaded
+	Bytes          int64     `json:"bytes"`            // total size of the files downloaded
+	Failures       int64     `json:"failures"`         // number of failed downloads
+	BytesPerSecond float64   `json:"bytes_per_second"` // average download rate since the session started
+}
+
+// Start the session now
+func (s *downloadStats) start() {
+	s.since.Store(time.Now().UnixNano())
+}
+
+// Record the result of a download
+func (s *downloadStats) record(d *Downloaded, err error) {
+	if err != nil {
+		s.failures.Add(1)
+		return
+	}
+	s.files.Add(1)
+	s.bytes.Add(d.Size)
+}
+
+// Returns the current stats
+func (s *downloadStats) snapshot() Stats {
+	stats := Stats{
+		Since:    time.Unix(0, s.since.Load()),
+		Files:    s.files.Load(),
+		Bytes:    s.bytes.Load(),
+		Failures: s.failures.Load(),
+	}
+	if elapsed := time.Since(stats.Since).Seconds(); elapsed > 0 {
+		stats.BytesPerSecond = float64(stats.Bytes) / elapsed
+	}
+	return stats
+}
+
+// Serve the download stats as JSON
+func (g *Gphotos) getStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(g.stats.snapshot())
+	if err != nil {
+		slog.Debug("Failed to write stats response", "err", err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Status is the state of the proxy returned by /status
+type Status struct {
+	Starting        bool     `json:"starting"`          // set until the browser has started
+	Restarting      bool     `json:"restarting"`        // set if the browser is being restarted
+	Degraded        bool     `json:"degraded"`          // set if the browser failed to restart too many times
+	Queued          int64    `json:"queued"`            // number of downloads waiting or in progress
+	ThrottleDelayMs int64    `json:"throttle_delay_ms"` // current delay between downloads
+	SkippedPhotos   []string `json:"skipped_photos"`    // photos skipped because they crash the browser
+	MaxPerHour      int      `json:"max_per_hour"`      // limit on downloads per hour from -max-per-hour (0 for none)
+	HourlyRemaining int      `json:"hourly_remaining"`  // downloads which can be started now under -max-per-hour (-1 for no limit)
+}
+
+// Serve the status of the proxy as JSON
+func (g *Gphotos) getStatus(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("got status request")
+	status := Status{
+		Starting:        g.starting.Load(),
+		Restarting:      g.restarting.Load(),
+		Degraded:        g.degraded.Load(),
+		Queued:          g.queued.Load(),
+		ThrottleDelayMs: g.throttle.Delay().Milliseconds(),
+		SkippedPhotos:   g.crashes.skipped(),
+		MaxPerHour:      max(*maxPerHour, 0),
+		HourlyRemaining: g.limiter.remaining(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		slog.Debug("Failed to write status response", "err", err)
+	}
+}
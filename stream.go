@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// DownloadStream downloads the photo with the ID given, sending it to
+// w while the browser is still downloading it.
+//
+// This reduces the time to first byte for large videos. Once anything
+// has been written to w, errors can't be reported to the client so are
+// just returned. The file is left in d.Path and should be deleted after
+// use as with Download.
+func (g *Gphotos) DownloadStream(photoID string, w http.ResponseWriter) (*Downloaded, error) {
+	d := &Downloaded{}
+	err := g.runDownload(photoID, d, func() error {
+		w.Header().Set("X-Queue-Wait-Ms", strconv.FormatInt(d.QueueWait.Milliseconds(), 10))
+		g.throttle.wait()
+		d.timings.mark("throttle")
+		err := g.preparePhoto(photoID, d)
+		if err == nil {
+			err = g.checkActivePhoto(photoID, d.RealID)
+		}
+		if err == nil {
+			if d.RealID != "" {
+				w.Header().Set("X-Real-Photo-ID", d.RealID)
+			}
+			err = g.streamDownload(d, w)
+			d.timings.mark("stream")
+		}
+		g.finishDownload(photoID, d, err)
+		return err
+	})
+	return d, err
+}
+
+// Press Shift-D and copy the file to w as the browser writes it
+func (g *Gphotos) streamDownload(d *Downloaded, w http.ResponseWriter) error {
+	browser, cancel := g.browser.WithCancel()
+	defer cancel()
+
+	err := proto.BrowserSetDownloadBehavior{
+		Behavior:         proto.BrowserSetDownloadBehaviorBehaviorAllowAndName,
+		BrowserContextID: browser.BrowserContextID,
+		DownloadPath:     downloadDir,
+	}.Call(browser)
+	if err != nil {
+		return fmt.Errorf("failed to set download behavior: %w", err)
+	}
+
+	// Pass the download events to this goroutine
+	starts := newDownloadStarts()
+	defer starts.removeExtra(browser)
+	progress := make(chan *proto.PageDownloadProgress, 16)
+	wait := browser.EachEvent(starts.began, func(e *proto.PageDownloadProgress) bool {
+		select {
+		case progress <- e:
+		case <-browser.GetContext().Done():
+			return true
+		}
+		return starts.finished(e)
+	})
+	go func() {
+		wait()
+		close(progress)
+	}()
+
+	// Shift-D or the download menu to download
+	start, err := triggerDownload(g, starts.ch)
+	if err != nil {
+		return err
+	}
+	d.Path = filepath.Join(downloadDir, start.GUID)
+	d.Name = start.SuggestedFilename
+	slog.Debug("Streaming download", "path", d.Path, "name", d.Name)
+
+	var (
+		in      *os.File
+		sent    int64
+		started bool
+	)
+	defer func() {
+		if in != nil {
+			_ = in.Close()
+		}
+	}()
+	rc := http.NewResponseController(w)
+	timeout, stop := downloadTimer()
+	defer stop()
+	for {
+		var e *proto.PageDownloadProgress
+		select {
+		case e = <-progress:
+		case <-timeout:
+			cancelDownload(browser, start.GUID)
+			return errDownloadTimeout()
+		case <-g.page.GetContext().Done():
+			cancelDownload(browser, start.GUID)
+			return fmt.Errorf("page went away while downloading: %w", g.page.GetContext().Err())
+		}
+		if e == nil {
+			return errors.New("browser stopped sending download events")
+		}
+		if e.GUID != start.GUID {
+			continue
+		}
+		if e.State == proto.PageDownloadProgressStateCanceled {
+			return errors.New("download was canceled by the browser")
+		}
+		// Check the size the browser expects, or has received so far if
+		// it doesn't know
+		err = checkFileSize(int64(max(e.TotalBytes, e.ReceivedBytes)))
+		if err != nil {
+			cancelDownload(browser, start.GUID)
+			return err
+		}
+		if in == nil {
+			in, err = openDownload(d.Path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return err
+			}
+		}
+		if !started {
+			// Send the headers before the first data
+			if ct := mime.TypeByExtension(strings.ToLower(filepath.Ext(d.Name))); ct != "" {
+				w.Header().Set("Content-Type", ct)
+			}
+			if d.Name != "" {
+				w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": d.Name}))
+			}
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if e.State == proto.PageDownloadProgressStateCompleted {
+			n, err := io.Copy(w, in)
+			sent += n
+			if err != nil {
+				return fmt.Errorf("failed to send download: %w", err)
+			}
+			d.Size = sent
+			slog.Debug("Stream download successful", "size", sent, "path", d.Path)
+			return nil
+		}
+		if toSend := int64(e.ReceivedBytes) - sent; toSend > 0 {
+			n, err := io.CopyN(w, in, toSend)
+			sent += n
+			if err != nil && !errors.Is(err, io.EOF) {
+				return fmt.Errorf("failed to send download: %w", err)
+			}
+			_ = rc.Flush()
+		}
+	}
+}
+
+// Open the download at path which may still be in progress
+func openDownload(path string) (*os.File, error) {
+	in, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Chrome may use a temporary name while downloading
+		in, err = os.Open(path + ".crdownload")
+	}
+	return in, err
+}
+
+// Serve a photo ID streaming it to the client while it downloads
+func (g *Gphotos) getIDStream(w http.ResponseWriter, photoID, requestID string) {
+	rw := &responseWriter{ResponseWriter: w}
+	d, err := g.DownloadStream(photoID, rw)
+	if d.Path != "" {
+		defer func() {
+			err := os.Remove(d.Path)
+			if err != nil && !os.IsNotExist(err) {
+				slog.Error("Failed to remove downloaded photo", "id", photoID, "path", d.Path, "err", err)
+			}
+		}()
+	}
+	if err != nil {
+		slog.Error("Stream download failed", "id", photoID, "sent", rw.n, "err", err)
+		if !rw.wroteHeader {
+			writeError(w, photoID, err)
+		}
+		return
+	}
+	slog.Info("Streamed photo", "id", photoID, "size", rw.n)
+	logTimings(requestID, photoID, d)
+}
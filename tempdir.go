@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How often to look for old files in the download directory
+const janitorInterval = 10 * time.Minute
+
+// Remove download directories left behind by earlier runs of
+// gphotosdl which didn't exit cleanly, eg because they crashed or were
+// killed
+//
+// Only directories which haven't been changed for -stale-temp-age are
+// removed. Each gphotosdl writes its PID to ownerFile in its download
+// directory, and directories belonging to a gphotosdl which is still
+// running are left alone however old they are, as they may hold its
+// copy of the browser profile.
+func removeStaleDownloadDirs() {
+	if *staleTempAge <= 0 {
+		return
+	}
+	dirs, err := filepath.Glob(filepath.Join(os.TempDir(), program+"*"))
+	if err != nil {
+		slog.Error("Failed to look for old download directories", "err", err)
+		return
+	}
+	for _, dir := range dirs {
+		if !isDownloadDirName(filepath.Base(dir)) {
+			continue
+		}
+		fi, err := os.Stat(dir)
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		age := time.Since(fi.ModTime())
+		if age < *staleTempAge {
+			continue
+		}
+		if owner, ok := otherOwner(dir); ok {
+			slog.Debug("Leaving old download directory of running gphotosdl", "download_directory", dir, "pid", owner)
+			continue
+		}
+		err = os.RemoveAll(dir)
+		if err != nil {
+			slog.Error("Failed to remove old download directory", "download_directory", dir, "err", err)
+			continue
+		}
+		slog.Info("Removed download directory left by an earlier run", "download_directory", dir, "age", age.Round(time.Second))
+	}
+}
+
+// Record this gphotosdl as the owner of the download directory so
+// other instances don't remove it while it is running
+func recordDownloadDirOwner() {
+	err := writeOwner(downloadDir)
+	if err != nil {
+		slog.Warn("Failed to record the owner of the download directory", "err", err)
+	}
+}
+
+// Returns true if name looks like one made by os.MkdirTemp for the
+// download directory, ie program followed by digits, so nothing else
+// starting with program is removed
+func isDownloadDirName(name string) bool {
+	suffix, found := strings.CutPrefix(name, program)
+	if !found || suffix == "" {
+		return false
+	}
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove files in the download directory older than -max-file-age
+// every janitorInterval
+//
+// Files are normally removed as soon as they have been served, so this
+// only catches ones leaked by handlers which didn't finish, eg because
+// they panicked, so the download directory can't grow without limit.
+func cleanDownloadDirEvery() {
+	for range time.Tick(janitorInterval) {
+		removeOldDownloads(*maxFileAge)
+	}
+}
+
+// Remove the files in the download directory which haven't changed
+// for maxAge
+//
+// Directories, like the copy of the browser profile, and ownerFile are
+// left alone.
+func removeOldDownloads(maxAge time.Duration) {
+	entries, err := os.ReadDir(downloadDir)
+	if err != nil {
+		slog.Error("Failed to read download directory", "download_directory", downloadDir, "err", err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || entry.Name() == ownerFile {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		age := time.Since(fi.ModTime())
+		if age < maxAge {
+			continue
+		}
+		path := filepath.Join(downloadDir, entry.Name())
+		err = os.Remove(path)
+		if err != nil {
+			slog.Error("Failed to remove old file from download directory", "path", path, "err", err)
+			continue
+		}
+		slog.Warn("Removed old file left in download directory", "path", path, "size", fi.Size(), "age", age.Round(time.Second))
+	}
+}
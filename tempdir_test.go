@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoveOldDownloads(t *testing.T) {
+	setVar(t, &downloadDir, t.TempDir())
+	const maxAge = time.Hour
+	tests := []struct {
+		name string
+		age  time.Duration
+		dir  bool
+		keep bool
+	}{
+		{name: "new.jpg", keep: true},
+		{name: "recent.jpg", age: maxAge - time.Minute, keep: true},
+		{name: "old.jpg", age: maxAge + time.Minute},
+		{name: "ancient.crdownload", age: 100 * maxAge},
+		{name: "browser", age: 100 * maxAge, dir: true, keep: true},
+		{name: ownerFile, age: 100 * maxAge, keep: true},
+	}
+	for _, test := range tests {
+		path := filepath.Join(downloadDir, test.name)
+		var err error
+		if test.dir {
+			err = os.Mkdir(path, 0700)
+		} else {
+			err = os.WriteFile(path, []byte("data"), 0600)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-test.age)
+		err = os.Chtimes(path, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removeOldDownloads(maxAge)
+
+	for _, test := range tests {
+		_, err := os.Stat(filepath.Join(downloadDir, test.name))
+		if kept := err == nil; kept != test.keep {
+			t.Errorf("%q: want kept %v, got %v", test.name, test.keep, kept)
+		}
+	}
+}
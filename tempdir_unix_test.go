@@ -0,0 +1,56 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRemoveStaleDownloadDirs(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	setVar(t, staleTempAge, time.Hour)
+	owner := startProcess(t, "sh", "-c", "sleep 30; true", program)
+	tests := []struct {
+		name  string
+		age   time.Duration
+		owner int // PID in ownerFile or 0 for none
+		keep  bool
+	}{
+		{name: program + "1", age: 2 * time.Hour},
+		{name: program + "2", age: time.Minute, keep: true},
+		{name: program + "3", age: 2 * time.Hour, owner: owner, keep: true},
+		{name: program + "4", age: 2 * time.Hour, owner: exitedPID(t)},
+		{name: program + "-other", age: 2 * time.Hour, keep: true},
+	}
+	for _, test := range tests {
+		dir := filepath.Join(os.TempDir(), test.name)
+		err := os.Mkdir(dir, 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.owner != 0 {
+			err = os.WriteFile(filepath.Join(dir, ownerFile), []byte(strconv.Itoa(test.owner)+"\n"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		modTime := time.Now().Add(-test.age)
+		err = os.Chtimes(dir, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removeStaleDownloadDirs()
+
+	for _, test := range tests {
+		_, err := os.Stat(filepath.Join(os.TempDir(), test.name))
+		if kept := err == nil; kept != test.keep {
+			t.Errorf("%q: want kept %v, got %v", test.name, test.keep, kept)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	throttleInitial   = 2 * time.Second // delay to start at when rate limited
+	throttleStep      = time.Second     // how much to reduce the delay by after throttleSuccesses
+	throttleSuccesses = 10              // number of successes in a row before reducing the delay
+	softBlockFailures = 3               // number of downloads in a row which don't start before backing off
+)
+
+// throttle adapts the delay between downloads to Google's rate limits
+//
+// The delay is doubled whenever Google returns 429 Too Many Requests
+// and reduced by throttleStep after throttleSuccesses successful
+// downloads in a row, so it settles just under the rate Google allows.
+//
+// Google doesn't always return 429. Sometimes it quietly stops
+// downloads starting instead, so softBlockFailures downloads in a row
+// which don't start are treated the same way.
+type throttle struct {
+	mu        sync.Mutex
+	delay     time.Duration // current delay between downloads
+	last      time.Time     // when the last download finished
+	successes int           // number of successes since the delay was last changed
+	noStarts  int           // number of downloads in a row which didn't start
+}
+
+// Wait until the delay has passed since the last download
+func (t *throttle) wait() {
+	t.mu.Lock()
+	sleep := time.Until(t.last.Add(t.delay))
+	t.mu.Unlock()
+	if sleep > 0 {
+		slog.Debug("Throttling download", "sleep", sleep)
+		time.Sleep(sleep)
+	}
+}
+
+// Record the result of a download and adjust the delay
+func (t *throttle) record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last = time.Now()
+	if *maxThrottle <= 0 {
+		return
+	}
+	if errors.Is(err, upstreamError(http.StatusTooManyRequests)) {
+		t.backOff()
+		slog.Warn("Google is rate limiting downloads - increasing delay", "delay", t.delay)
+		return
+	}
+	if errors.Is(err, errNoDownload) {
+		t.noStarts++
+		if t.noStarts >= softBlockFailures {
+			t.backOff()
+			slog.Warn("Google appears to be rate limiting downloads, backing off", "downloads_not_started", t.noStarts, "delay", t.delay)
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+	t.noStarts = 0
+	if t.delay == 0 {
+		return
+	}
+	t.successes++
+	if t.successes >= throttleSuccesses {
+		t.delay = max(t.delay-throttleStep, 0)
+		t.successes = 0
+		slog.Info("Downloads succeeding - reducing delay", "delay", t.delay)
+	}
+}
+
+// Double the delay between downloads up to -max-throttle
+//
+// This must be called with the lock held.
+func (t *throttle) backOff() {
+	t.delay = min(max(2*t.delay, throttleInitial), *maxThrottle)
+	t.successes = 0
+}
+
+// Delay returns the current delay between downloads
+func (t *throttle) Delay() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.delay
+}
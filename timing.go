@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Last request ID handed out by newRequestID
+var lastRequestID atomic.Uint64
+
+// Returns a new ID to tie together the log lines for a request
+func newRequestID() string {
+	return strconv.FormatUint(lastRequestID.Add(1), 10)
+}
+
+// phaseTimings records how long each phase of a download took
+//
+// This is only written by the download worker, so it must only be
+// read once the download has finished.
+type phaseTimings struct {
+	last   time.Time
+	phases []any
+}
+
+// Start timing the first phase
+func (t *phaseTimings) start() {
+	t.last = time.Now()
+}
+
+// Record the time since the last phase ended as the time taken by
+// phase
+func (t *phaseTimings) mark(phase string) {
+	if t.last.IsZero() {
+		return
+	}
+	now := time.Now()
+	t.phases = append(t.phases, slog.Duration(phase, now.Sub(t.last)))
+	t.last = now
+}
+
+// Call fn with the name and duration of each phase in the order they
+// happened
+func (t *phaseTimings) each(fn func(phase string, d time.Duration)) {
+	for _, phase := range t.phases {
+		if attr, ok := phase.(slog.Attr); ok {
+			fn(attr.Key, attr.Value.Duration())
+		}
+	}
+}
+
+// Log the time each phase of the download in d took at debug level,
+// followed by extra phases timed by the caller
+//
+// This shows whether Google, the browser or the disk is slowing
+// downloads down.
+func logTimings(requestID, photoID string, d *Downloaded, extra ...any) {
+	args := []any{"request_id", requestID, "id", photoID, slog.Duration("queue", d.QueueWait)}
+	args = append(args, d.timings.phases...)
+	args = append(args, extra...)
+	slog.Debug("Request timings", args...)
+}
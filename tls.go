@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Set up the web server to use TLS if -tls-cert and -tls-key are set
+//
+// With TLS, clients which support it use HTTP/2 which lets all of
+// rclone's transfers share one connection. -http2=false turns this off.
+func (g *Gphotos) configureTLS() (useTLS bool, err error) {
+	if *tlsCert == "" && *tlsKey == "" {
+		return false, nil
+	}
+	if *tlsCert == "" || *tlsKey == "" {
+		return false, errors.New("-tls-cert and -tls-key must be used together")
+	}
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	g.server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if !*http2 {
+		// A non-nil empty map stops the server offering HTTP/2
+		g.server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return true, nil
+}
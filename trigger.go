@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/input"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Selectors for the download item in the photo's overflow menu
+const (
+	overflowMenuButton = `[aria-label="More options"]`
+	overflowMenuItem   = `[role="menuitem"]`
+	overflowMenuText   = `^\s*Download\s*$`
+)
+
+// Number of times to press Shift-D before trying the download menu
+const shiftDTries = 2
+
+// errNoDownload is returned if the browser didn't start a download
+// when asked to
+var errNoDownload = fmt.Errorf("download didn't start: %w", errKindTimeout)
+
+// Start the download of the photo showing and wait for started to
+// receive, returning what it received
+//
+// This focuses the page and presses Shift-D. The key press is lost if
+// the page doesn't have focus, so if the download doesn't start within
+// -download-start-grace it tries again rather than waiting for the full
+// -wait-timeout. If that doesn't work it uses Download in the photo's
+// overflow menu in case Google has changed the shortcut.
+func triggerDownload[T any](g *Gphotos, started <-chan T) (T, error) {
+	var zero T
+	for try := 1; try <= shiftDTries; try++ {
+		// The last key press may have started the download just after
+		// the grace ran out, and pressing again would start another
+		select {
+		case e := <-started:
+			return e, nil
+		default:
+		}
+		err := g.focusPage()
+		if err != nil {
+			slog.Debug("Failed to focus page", "err", err)
+		}
+		err = g.page.KeyActions().Press(input.ShiftLeft).Type('D').Do()
+		if err != nil {
+			slog.Warn("Failed to press Shift-D", "try", try, "err", err)
+			continue
+		}
+		select {
+		case e := <-started:
+			return e, nil
+		case <-time.After(*downloadStartGrace):
+			slog.Warn("Download didn't start after Shift-D", "try", try, "grace", *downloadStartGrace)
+		}
+	}
+	select {
+	case e := <-started:
+		return e, nil
+	default:
+	}
+	slog.Warn("Trying the download menu")
+	err := g.clickDownloadMenu()
+	if err != nil {
+		return zero, fmt.Errorf("%w and the download menu failed: %v", errNoDownload, err)
+	}
+	select {
+	case e := <-started:
+		slog.Debug("Download started from the download menu")
+		return e, nil
+	case <-time.After(*waitTimeout):
+		return zero, errNoDownload
+	}
+}
+
+// downloadStarts passes the first download the browser starts to
+// triggerDownload and remembers any others
+//
+// The others are duplicates, started when a key press which seemed to
+// have been lost took effect late.
+type downloadStarts struct {
+	ch    chan *proto.PageDownloadWillBegin
+	mu    sync.Mutex
+	guid  string   // GUID of the first download once it has been passed on
+	extra []string // GUIDs of the duplicate downloads
+}
+
+// Returns a new downloadStarts
+func newDownloadStarts() *downloadStarts {
+	return &downloadStarts{ch: make(chan *proto.PageDownloadWillBegin, 1)}
+}
+
+// Record the download e which the browser has started
+func (s *downloadStarts) began(e *proto.PageDownloadWillBegin) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.guid == "" {
+		s.guid = e.GUID
+		s.ch <- e
+		return
+	}
+	slog.Warn("Browser started a duplicate download - it will be removed", "guid", e.GUID)
+	s.extra = append(s.extra, e.GUID)
+}
+
+// Returns true if e shows the first download has stopped, so there is
+// no need to listen for more events
+//
+// Duplicate downloads stopping don't count.
+func (s *downloadStarts) finished(e *proto.PageDownloadProgress) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return e.GUID == s.guid && e.State != proto.PageDownloadProgressStateInProgress
+}
+
+// Cancel the duplicate downloads and remove their files
+//
+// Call this once the download passed on has finished.
+func (s *downloadStarts) removeExtra(browser *rod.Browser) {
+	s.mu.Lock()
+	extra := s.extra
+	s.extra = nil
+	s.mu.Unlock()
+	for _, guid := range extra {
+		cancelDownload(browser, guid)
+		path := filepath.Join(downloadDir, guid)
+		for _, name := range []string{path, path + ".crdownload"} {
+			err := os.Remove(name)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Error("Failed to remove duplicate download", "path", name, "err", err)
+			}
+		}
+	}
+}
+
+// Give the page the keyboard focus so Shift-D reaches it
+func (g *Gphotos) focusPage() error {
+	_, err := g.page.Activate()
+	if err != nil {
+		return fmt.Errorf("failed to activate page: %w", err)
+	}
+	// Make the page think it has focus even if the window doesn't
+	err = proto.EmulationSetFocusEmulationEnabled{Enabled: true}.Call(g.page)
+	if err != nil {
+		return fmt.Errorf("failed to emulate focus: %w", err)
+	}
+	_, err = g.page.Timeout(*waitTimeout).Eval(`() => {
+		window.focus();
+		if (document.activeElement && document.activeElement !== document.body) {
+			return;
+		}
+		if (document.body) {
+			document.body.focus();
+		}
+	}`)
+	if err != nil {
+		return fmt.Errorf("failed to focus page: %w", err)
+	}
+	return nil
+}
+
+// Open the photo's overflow menu and click Download
+func (g *Gphotos) clickDownloadMenu() error {
+	page := g.page.Timeout(*waitTimeout)
+	button, err := page.Element(overflowMenuButton)
+	if err != nil {
+		return fmt.Errorf("failed to find overflow menu: %w", err)
+	}
+	err = button.Click("left", 1)
+	if err != nil {
+		return fmt.Errorf("failed to open overflow menu: %w", err)
+	}
+	item, err := page.ElementR(overflowMenuItem, overflowMenuText)
+	if err != nil {
+		return fmt.Errorf("failed to find Download in overflow menu: %w", err)
+	}
+	err = item.Click("left", 1)
+	if err != nil {
+		return fmt.Errorf("failed to click Download: %w", err)
+	}
+	return nil
+}
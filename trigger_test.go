@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func TestDownloadStarts(t *testing.T) {
+	s := newDownloadStarts()
+	for _, guid := range []string{"first", "late", "later"} {
+		s.began(&proto.PageDownloadWillBegin{GUID: guid})
+	}
+	select {
+	case e := <-s.ch:
+		if e.GUID != "first" {
+			t.Errorf("want the first download passed on, got %q", e.GUID)
+		}
+	default:
+		t.Fatal("want the first download passed on, got none")
+	}
+	select {
+	case e := <-s.ch:
+		t.Errorf("want only one download passed on, got %q too", e.GUID)
+	default:
+	}
+	if want := []string{"late", "later"}; !slices.Equal(s.extra, want) {
+		t.Errorf("want duplicates %q, got %q", want, s.extra)
+	}
+}
+
+func TestDownloadStartsFinished(t *testing.T) {
+	s := newDownloadStarts()
+	s.began(&proto.PageDownloadWillBegin{GUID: "first"})
+	s.began(&proto.PageDownloadWillBegin{GUID: "late"})
+	for _, test := range []struct {
+		guid  string
+		state proto.PageDownloadProgressState
+		want  bool
+	}{
+		{"first", proto.PageDownloadProgressStateInProgress, false},
+		{"first", proto.PageDownloadProgressStateCompleted, true},
+		{"first", proto.PageDownloadProgressStateCanceled, true},
+		{"late", proto.PageDownloadProgressStateCompleted, false},
+		{"late", proto.PageDownloadProgressStateCanceled, false},
+		{"other", proto.PageDownloadProgressStateCompleted, false},
+	} {
+		got := s.finished(&proto.PageDownloadProgress{GUID: test.guid, State: test.state})
+		if got != test.want {
+			t.Errorf("%s %s: want %v, got %v", test.guid, test.state, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// downloadJob is a download for the download worker to do
+type downloadJob struct {
+	photoID string
+	d       *Downloaded
+	queued  time.Time    // when the job was submitted
+	fn      func() error // does the download
+	done    chan error   // receives the result of fn
+}
+
+// Submit a download of photoID to the download worker and wait for it
+// to finish
+//
+// fn is run by the worker with the download lock held, after setting
+// d.QueueWait to the time spent waiting for the worker.
+func (g *Gphotos) runDownload(photoID string, d *Downloaded, fn func() error) error {
+	err := checkPhotoID(photoID)
+	if err != nil {
+		return err
+	}
+	err = g.checkBrowser()
+	if err != nil {
+		return err
+	}
+	err = g.crashes.check(photoID)
+	if err != nil {
+		return err
+	}
+
+	// Don't let requests pile up waiting for the worker
+	queued := g.queued.Add(1)
+	defer g.queued.Add(-1)
+	if *maxQueue > 0 && queued > int64(*maxQueue) {
+		slog.Debug("Download queue full", "id", photoID, "queued", queued-1)
+		return errQueueFull
+	}
+	err = g.limiter.take()
+	if err != nil {
+		slog.Debug("Hourly download limit reached", "id", photoID)
+		return err
+	}
+
+	job := &downloadJob{
+		photoID: photoID,
+		d:       d,
+		queued:  time.Now(),
+		fn:      fn,
+		done:    make(chan error, 1),
+	}
+	g.jobs <- job
+	return <-job.done
+}
+
+// Do the download jobs one at a time
+func (g *Gphotos) downloadWorker() {
+	for job := range g.jobs {
+		err := g.runJob(job)
+		job.done <- err
+		// Recover before the next job so it gets a working page
+		if reason, ok := recoveryFor(err); ok {
+			g.recover(reason)
+		}
+	}
+}
+
+// Do a single download job with the lock held
+//
+// A panic in the job, eg from rod, is returned as an error so it
+// doesn't stop the worker.
+func (g *Gphotos) runJob(job *downloadJob) (err error) {
+	// Can only download one picture at once
+	lockStart := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	lockWait := time.Since(lockStart)
+	job.d.QueueWait = time.Since(job.queued)
+	g.lockWait.observe(lockWait)
+	g.queueWait.observe(job.d.QueueWait)
+	slog.Debug("Acquired download lock", "id", job.photoID, "lock_wait", lockWait, "queue_wait", job.d.QueueWait)
+	job.d.timings.start()
+	g.crashes.setCurrent(job.photoID)
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Download panicked", "id", job.photoID, "panic", r)
+			err = fmt.Errorf("download failed: %v", r)
+		}
+		g.crashes.setCurrent("")
+		g.logPageMemory("After download")
+		g.schedulePark()
+	}()
+	// Give the job a page which can be cancelled if it gets stuck
+	page := g.page
+	ctx, cancel := context.WithCancel(page.GetContext())
+	g.page = page.Context(ctx)
+	g.cancelJob.Store(&cancel)
+	defer func() {
+		g.cancelJob.Store(nil)
+		cancel()
+		// Leave any page the job opened with -reset page in place
+		if g.page.GetContext() == ctx {
+			g.page = page
+		}
+	}()
+	err = g.ensureDownloadDir()
+	if err == nil {
+		err = checkFreeSpace()
+	}
+	if err != nil {
+		// The browser wasn't used so this doesn't count towards -max-per-hour
+		g.limiter.giveBack()
+		return err
+	}
+	return job.fn()
+}
+
+// Cancel the page context of the running download, if any, which
+// makes it fail and release the lock
+func (g *Gphotos) cancelRunningJob() {
+	if cancel := g.cancelJob.Load(); cancel != nil {
+		(*cancel)()
+	}
+}